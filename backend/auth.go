@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookieName = "kiroshi_session"
+	sessionTTL        = 30 * 24 * time.Hour
+
+	roleAdmin  = "admin"
+	roleViewer = "viewer"
+)
+
+type userCtxKey struct{}
+
+var (
+	errUserExists = errors.New("username already taken")
+	setupMu       sync.Mutex
+
+	// dummyPasswordHash is compared against when a username does not exist,
+	// so unknown usernames take as long to reject as wrong passwords.
+	dummyPasswordHash = sync.OnceValue(func() []byte {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("kiroshi"), bcrypt.DefaultCost)
+		return hash
+	})
+)
+
+type UserRepository interface {
+	CreateUser(username, passwordHash, role string) (user, error)
+	GetUser(id int64) (user, string, error)
+	GetUserByName(username string) (user, string, error)
+	ListUsers() ([]user, error)
+	DeleteUser(id int64) error
+	CountUsers() (int, error)
+	SetUserLocale(userId int64, language, region string) error
+
+	CreateSession(tokenHash string, userId int64, expiresAt time.Time) error
+	GetSessionUser(tokenHash string) (user, error)
+	DeleteSession(tokenHash string) error
+	DeleteExpiredSessions() error
+}
+
+type user struct {
+	Id        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	Language  string    `json:"language"`
+	Region    string    `json:"region"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role,omitempty"`
+}
+
+// publicRoutes can be reached without a session. Everything outside /api is
+// the SPA and is always public, the login page lives there.
+var publicRoutes = map[string]bool{
+	"POST /api/login": true,
+	"GET /api/setup":  true,
+	"POST /api/setup": true,
+}
+
+func withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || publicRoutes[r.Method+" "+r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u, ok := sessionUser(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userCtxKey{}, u)))
+	})
+}
+
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if u, ok := currentUser(r); !ok || u.Role != roleAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func currentUser(r *http.Request) (user, bool) {
+	u, ok := r.Context().Value(userCtxKey{}).(user)
+	return u, ok
+}
+
+func sessionUser(r *http.Request) (user, bool) {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil || c.Value == "" {
+		return user{}, false
+	}
+	u, err := store.GetSessionUser(hashToken(c.Value))
+	if err != nil {
+		return user{}, false
+	}
+	return u, true
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// initAuth creates the initial admin from ADMIN_USERNAME and ADMIN_PASSWORD
+// on first run. Without them the first user is created through /api/setup
+// instead.
+func initAuth() {
+	if err := store.DeleteExpiredSessions(); err != nil {
+		slog.Warn("Failed to delete expired sessions", "err", err)
+	}
+
+	n, err := store.CountUsers()
+	if err != nil {
+		fatal("Failed to count users", "err", err)
+	}
+	if n > 0 {
+		return
+	}
+	if cfg.AdminUsername == "" || cfg.AdminPassword == "" {
+		slog.Info("No users yet, create the initial admin through the setup page")
+		return
+	}
+	if _, err := createUser(cfg.AdminUsername, cfg.AdminPassword, roleAdmin); err != nil {
+		fatal("Failed to create initial admin", "err", err)
+	}
+	slog.Info("Created initial admin", "user", cfg.AdminUsername)
+}
+
+func createUser(username, password, role string) (user, error) {
+	if role != roleAdmin && role != roleViewer {
+		return user{}, errors.New("invalid role")
+	}
+	if strings.TrimSpace(username) == "" || len(password) < 8 {
+		return user{}, errors.New("username required and password must be at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return user{}, err
+	}
+	return store.CreateUser(strings.TrimSpace(username), string(hash), role)
+}
+
+func startSession(w http.ResponseWriter, r *http.Request, u user) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	expires := time.Now().Add(sessionTTL)
+
+	if err := store.CreateSession(hashToken(token), u.Id, expires); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func handleSetupStatus(w http.ResponseWriter, r *http.Request) {
+	n, err := store.CountUsers()
+	if err != nil {
+		http.Error(w, "Failed to load users", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]bool{"required": n == 0})
+}
+
+func handleSetup(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	setupMu.Lock()
+	defer setupMu.Unlock()
+
+	n, err := store.CountUsers()
+	if err != nil {
+		http.Error(w, "Failed to load users", http.StatusInternalServerError)
+		return
+	}
+	if n > 0 {
+		http.Error(w, "Setup already completed", http.StatusConflict)
+		return
+	}
+
+	u, err := createUser(req.Username, req.Password, roleAdmin)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(r.Context(), "Created initial admin", "user", u.Username)
+
+	if err := startSession(w, r, u); err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, u)
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	u, hash, err := store.GetUserByName(req.Username)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(req.Password))
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	if err := startSession(w, r, u); err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "User logged in", "user", u.Username)
+	writeJSON(w, u)
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		store.DeleteSession(hashToken(c.Value))
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	u, _ := currentUser(r)
+	writeJSON(w, u)
+}
+
+func handleListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := store.ListUsers()
+	if err != nil {
+		http.Error(w, "Failed to load users", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, users)
+}
+
+func handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = roleViewer
+	}
+
+	u, err := createUser(req.Username, req.Password, req.Role)
+	if errors.Is(err, errUserExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(r.Context(), "Created user", "user", u.Username, "role", u.Role)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(u)
+}
+
+func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+	if me, _ := currentUser(r); me.Id == id {
+		http.Error(w, "Cannot delete yourself", http.StatusBadRequest)
+		return
+	}
+	if err := store.DeleteUser(id); err != nil {
+		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Store) CreateUser(username, passwordHash, role string) (user, error) {
+	now := time.Now()
+	res, err := s.db.Exec(
+		"INSERT INTO users (username, password_hash, role, created_at) VALUES (?, ?, ?, ?)",
+		username, passwordHash, role, now.Unix(),
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return user{}, errUserExists
+		}
+		return user{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return user{}, err
+	}
+	return user{Id: id, Username: username, Role: role, CreatedAt: time.Unix(now.Unix(), 0)}, nil
+}
+
+func (s *Store) GetUser(id int64) (user, string, error) {
+	return s.scanUser(s.db.QueryRow("SELECT id, username, role, language, region, created_at, password_hash FROM users WHERE id = ?", id))
+}
+
+func (s *Store) GetUserByName(username string) (user, string, error) {
+	return s.scanUser(s.db.QueryRow("SELECT id, username, role, language, region, created_at, password_hash FROM users WHERE username = ?", username))
+}
+
+func (s *Store) scanUser(row *sql.Row) (user, string, error) {
+	var u user
+	var createdAt int64
+	var hash string
+	if err := row.Scan(&u.Id, &u.Username, &u.Role, &u.Language, &u.Region, &createdAt, &hash); err != nil {
+		return user{}, "", err
+	}
+	u.CreatedAt = time.Unix(createdAt, 0)
+	return u, hash, nil
+}
+
+func (s *Store) ListUsers() ([]user, error) {
+	rows, err := s.db.Query("SELECT id, username, role, language, region, created_at FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []user{}
+	for rows.Next() {
+		var u user
+		var createdAt int64
+		if err := rows.Scan(&u.Id, &u.Username, &u.Role, &u.Language, &u.Region, &createdAt); err != nil {
+			return nil, err
+		}
+		u.CreatedAt = time.Unix(createdAt, 0)
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) DeleteUser(id int64) error {
+	_, err := s.db.Exec("DELETE FROM users WHERE id = ?", id)
+	return err
+}
+
+func (s *Store) CountUsers() (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n)
+	return n, err
+}
+
+func (s *Store) CreateSession(tokenHash string, userId int64, expiresAt time.Time) error {
+	_, err := s.db.Exec(
+		"INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		tokenHash, userId, expiresAt.Unix(),
+	)
+	return err
+}
+
+func (s *Store) GetSessionUser(tokenHash string) (user, error) {
+	var u user
+	var createdAt int64
+	err := s.db.QueryRow(
+		`SELECT u.id, u.username, u.role, u.language, u.region, u.created_at FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?`,
+		tokenHash, time.Now().Unix(),
+	).Scan(&u.Id, &u.Username, &u.Role, &u.Language, &u.Region, &createdAt)
+	if err != nil {
+		return user{}, err
+	}
+	u.CreatedAt = time.Unix(createdAt, 0)
+	return u, nil
+}
+
+func (s *Store) DeleteSession(tokenHash string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+func (s *Store) DeleteExpiredSessions() error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now().Unix())
+	return err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// authTestHandler wires the auth routes and one route per access level
+// behind withAuth, like main does.
+func authTestHandler(t *testing.T) http.Handler {
+	t.Helper()
+	store = openTestStore(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/setup", handleSetupStatus)
+	mux.HandleFunc("POST /api/setup", handleSetup)
+	mux.HandleFunc("POST /api/login", handleLogin)
+	mux.HandleFunc("GET /api/me", handleMe)
+	mux.HandleFunc("GET /api/users", requireAdmin(handleListUsers))
+	mux.HandleFunc("POST /api/users", requireAdmin(handleCreateUser))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("spa"))
+	})
+	return withAuth(mux)
+}
+
+func serveAuth(h http.Handler, method, target, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func sessionCookie(t *testing.T, rec *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookieName && c.Value != "" {
+			return c
+		}
+	}
+	t.Fatalf("no session cookie in response: %d %s", rec.Code, rec.Body)
+	return nil
+}
+
+func TestSetupCreatesAdminOnce(t *testing.T) {
+	h := authTestHandler(t)
+
+	var status map[string]bool
+	rec := serveAuth(h, http.MethodGet, "/api/setup", "")
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || !status["required"] {
+		t.Fatalf("setup status = %v, %v", status, err)
+	}
+
+	if rec := serveAuth(h, http.MethodPost, "/api/setup", `{"username": "admin", "password": "short"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("short password: status = %d", rec.Code)
+	}
+
+	rec = serveAuth(h, http.MethodPost, "/api/setup", `{"username": "admin", "password": "correct horse"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("setup: status = %d: %s", rec.Code, rec.Body)
+	}
+	cookie := sessionCookie(t, rec)
+
+	var me user
+	rec = serveAuth(h, http.MethodGet, "/api/me", "", cookie)
+	if err := json.NewDecoder(rec.Body).Decode(&me); err != nil || me.Username != "admin" || me.Role != roleAdmin {
+		t.Errorf("me after setup = %+v, %v", me, err)
+	}
+
+	rec = serveAuth(h, http.MethodGet, "/api/setup", "")
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || status["required"] {
+		t.Errorf("setup status after setup = %v, %v", status, err)
+	}
+	if rec := serveAuth(h, http.MethodPost, "/api/setup", `{"username": "mallory", "password": "correct horse"}`); rec.Code != http.StatusConflict {
+		t.Errorf("second setup: status = %d", rec.Code)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	h := authTestHandler(t)
+	if _, err := createUser("alice", "correct horse", roleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{
+		`{"username": "alice", "password": "wrong horse"}`,
+		`{"username": "nobody", "password": "correct horse"}`,
+	} {
+		rec := serveAuth(h, http.MethodPost, "/api/login", body)
+		if rec.Code != http.StatusUnauthorized || len(rec.Result().Cookies()) != 0 {
+			t.Errorf("%s: status = %d, cookies %v", body, rec.Code, rec.Result().Cookies())
+		}
+	}
+
+	rec := serveAuth(h, http.MethodPost, "/api/login", `{"username": "alice", "password": "correct horse"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serveAuth(h, http.MethodGet, "/api/me", "", sessionCookie(t, rec)); rec.Code != http.StatusOK {
+		t.Errorf("me with session: status = %d", rec.Code)
+	}
+}
+
+func TestWithAuthRejectsMissingAndExpiredSessions(t *testing.T) {
+	h := authTestHandler(t)
+	u, err := createUser("alice", "correct horse", roleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateSession(hashToken("expired"), u.Id, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serveAuth(h, http.MethodGet, "/api/me", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no cookie: status = %d", rec.Code)
+	}
+	expired := &http.Cookie{Name: sessionCookieName, Value: "expired"}
+	if rec := serveAuth(h, http.MethodGet, "/api/me", "", expired); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired session: status = %d", rec.Code)
+	}
+	unknown := &http.Cookie{Name: sessionCookieName, Value: "made-up"}
+	if rec := serveAuth(h, http.MethodGet, "/api/me", "", unknown); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown session: status = %d", rec.Code)
+	}
+
+	// Only the listed methods of public routes skip the session check.
+	if rec := serveAuth(h, http.MethodDelete, "/api/setup", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("DELETE /api/setup without cookie: status = %d", rec.Code)
+	}
+	if rec := serveAuth(h, http.MethodGet, "/movies/603", ""); rec.Code != http.StatusOK || rec.Body.String() != "spa" {
+		t.Errorf("SPA route: status = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	h := authTestHandler(t)
+	for _, c := range []struct{ name, role string }{{"admin", roleAdmin}, {"viewer", roleViewer}} {
+		if _, err := createUser(c.name, "correct horse", c.role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	login := func(name string) *http.Cookie {
+		return sessionCookie(t, serveAuth(h, http.MethodPost, "/api/login", `{"username": "`+name+`", "password": "correct horse"}`))
+	}
+
+	if rec := serveAuth(h, http.MethodGet, "/api/users", "", login("viewer")); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer: status = %d", rec.Code)
+	}
+	admin := login("admin")
+	if rec := serveAuth(h, http.MethodGet, "/api/users", "", admin); rec.Code != http.StatusOK {
+		t.Errorf("admin: status = %d", rec.Code)
+	}
+
+	rec := serveAuth(h, http.MethodPost, "/api/users", `{"username": "carol", "password": "correct horse"}`, admin)
+	var created user
+	if rec.Code != http.StatusCreated || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("create user: status = %d, Content-Type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil || created.Username != "carol" || created.Role != roleViewer {
+		t.Errorf("created user = %+v, %v", created, err)
+	}
+	if rec := serveAuth(h, http.MethodPost, "/api/users", `{"username": "dave", "password": "correct horse"}`, login("viewer")); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer creating a user: status = %d", rec.Code)
+	}
+}
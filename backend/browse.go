@@ -0,0 +1,213 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// availability hints whether a title can be played without searching
+// indexers and whether the user has seen it. For shows they refer to any
+// episode.
+type availability struct {
+	Local   bool `json:"local"`
+	Watched bool `json:"watched"`
+}
+
+type availableMedia struct {
+	mediaSummary
+	Availability availability `json:"availability"`
+}
+
+type personCredit struct {
+	availableMedia
+	Character string   `json:"character,omitempty"`
+	Jobs      []string `json:"jobs,omitempty"`
+}
+
+type personDetails struct {
+	Id           int            `json:"id"`
+	ImdbId       string         `json:"imdbId"`
+	Name         string         `json:"name"`
+	Biography    string         `json:"biography"`
+	Birthday     string         `json:"birthday"`
+	Deathday     string         `json:"deathday"`
+	PlaceOfBirth string         `json:"placeOfBirth"`
+	KnownFor     string         `json:"knownFor"`
+	ProfilePath  string         `json:"profilePath"`
+	Credits      []personCredit `json:"credits"`
+}
+
+type collectionDetails struct {
+	Id           int              `json:"id"`
+	Name         string           `json:"name"`
+	Overview     string           `json:"overview"`
+	PosterPath   string           `json:"posterPath"`
+	BackdropPath string           `json:"backdropPath"`
+	Parts        []availableMedia `json:"parts"`
+}
+
+type mediaRef struct {
+	Type string
+	Id   int
+}
+
+// availabilityIndex answers availability for many titles with one progress
+// query and one pass over the library.
+type availabilityIndex struct {
+	local   map[mediaRef]bool
+	watched map[mediaRef]bool
+}
+
+func newAvailabilityIndex(userId int64) (availabilityIndex, error) {
+	idx := availabilityIndex{local: map[mediaRef]bool{}, watched: map[mediaRef]bool{}}
+	for _, e := range findLibraryEntries("", 0, 0, 0) {
+		idx.local[libraryRef(e.Type, e.TmdbId)] = true
+	}
+	history, err := store.ListProgress(userId)
+	for _, p := range history {
+		if p.Watched {
+			idx.watched[libraryRef(p.Type, p.TmdbId)] = true
+		}
+	}
+	return idx, err
+}
+
+// libraryRef maps the movie and episode types of library entries and
+// progress to the title they belong to.
+func libraryRef(mediaType string, tmdbId int) mediaRef {
+	if mediaType == "episode" {
+		return mediaRef{"show", tmdbId}
+	}
+	return mediaRef{mediaType, tmdbId}
+}
+
+func (idx availabilityIndex) get(m mediaSummary) availableMedia {
+	ref := mediaRef{m.Type, m.Id}
+	return availableMedia{mediaSummary: m, Availability: availability{Local: idx.local[ref], Watched: idx.watched[ref]}}
+}
+
+func newPersonDetails(p tmdbPerson, idx availabilityIndex) personDetails {
+	d := personDetails{
+		Id:           p.Id,
+		ImdbId:       p.ImdbId,
+		Name:         p.Name,
+		Biography:    p.Biography,
+		Birthday:     p.Birthday,
+		Deathday:     p.Deathday,
+		PlaceOfBirth: p.PlaceOfBirth,
+		KnownFor:     p.KnownForDepartment,
+		ProfilePath:  p.ProfilePath,
+		Credits:      []personCredit{},
+	}
+
+	// A title appears once per role, merge them into one credit.
+	byRef := map[mediaRef]int{}
+	credit := func(c tmdbPersonCredit) *personCredit {
+		if c.MediaType != "movie" && c.MediaType != "tv" {
+			return nil
+		}
+		m := newMediaSummary(c.tmdbSearchResult, c.MediaType)
+		ref := mediaRef{m.Type, m.Id}
+		if i, ok := byRef[ref]; ok {
+			return &d.Credits[i]
+		}
+		byRef[ref] = len(d.Credits)
+		d.Credits = append(d.Credits, personCredit{availableMedia: idx.get(m)})
+		return &d.Credits[len(d.Credits)-1]
+	}
+	for _, c := range p.CombinedCredits.Cast {
+		if pc := credit(c); pc != nil && pc.Character == "" {
+			pc.Character = c.Character
+		}
+	}
+	for _, c := range p.CombinedCredits.Crew {
+		if pc := credit(c); pc != nil && c.Job != "" && !slices.Contains(pc.Jobs, c.Job) {
+			pc.Jobs = append(pc.Jobs, c.Job)
+		}
+	}
+
+	// Newest first, titles without a date last.
+	slices.SortStableFunc(d.Credits, func(a, b personCredit) int {
+		if a.ReleaseDate == "" || b.ReleaseDate == "" {
+			return compareDates(a.ReleaseDate, b.ReleaseDate)
+		}
+		return compareDates(b.ReleaseDate, a.ReleaseDate)
+	})
+	return d
+}
+
+func newCollectionDetails(c tmdbCollection, idx availabilityIndex) collectionDetails {
+	d := collectionDetails{
+		Id:           c.Id,
+		Name:         c.Name,
+		Overview:     c.Overview,
+		PosterPath:   c.PosterPath,
+		BackdropPath: c.BackdropPath,
+		Parts:        []availableMedia{},
+	}
+	for _, part := range c.Parts {
+		d.Parts = append(d.Parts, idx.get(newMovieSummary(part)))
+	}
+	slices.SortStableFunc(d.Parts, func(a, b availableMedia) int {
+		return compareDates(a.ReleaseDate, b.ReleaseDate)
+	})
+	return d
+}
+
+// compareDates orders release dates ascending, unknown dates last.
+func compareDates(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+func requestAvailability(w http.ResponseWriter, r *http.Request) (availabilityIndex, bool) {
+	u, _ := currentUser(r)
+	idx, err := newAvailabilityIndex(u.Id)
+	if err != nil {
+		http.Error(w, "Failed to load progress", http.StatusInternalServerError)
+		return idx, false
+	}
+	return idx, true
+}
+
+func handlePerson(w http.ResponseWriter, r *http.Request) {
+	ids, ok := queryInts(w, r, "id")
+	if !ok {
+		return
+	}
+	p, err := metadata.Person(r.Context(), ids[0])
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+	idx, ok := requestAvailability(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, newPersonDetails(p, idx))
+}
+
+func handleCollection(w http.ResponseWriter, r *http.Request) {
+	ids, ok := queryInts(w, r, "id")
+	if !ok {
+		return
+	}
+	c, err := metadata.Collection(r.Context(), ids[0])
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+	idx, ok := requestAvailability(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, newCollectionDetails(c, idx))
+}
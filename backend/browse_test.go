@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func useLibrary(t *testing.T, entries ...libraryEntry) {
+	t.Helper()
+	libraryMu.Lock()
+	old := libraryEntries
+	libraryEntries = map[string]libraryEntry{}
+	for _, e := range entries {
+		libraryEntries[e.Id] = e
+	}
+	libraryMu.Unlock()
+	t.Cleanup(func() {
+		libraryMu.Lock()
+		libraryEntries = old
+		libraryMu.Unlock()
+	})
+}
+
+func TestPersonMergesRolesWithAvailability(t *testing.T) {
+	useMetadata(t, fixtureProvider{dir: "testdata/metadata"})
+	useLibrary(t, libraryEntry{Id: "a", Type: "movie", TmdbId: 604})
+	store = openTestStore(t)
+	u, err := store.CreateUser("alice", "hash", roleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveProgress(progress{mediaKey: mediaKey{Type: "movie", TmdbId: 603}, Watched: true, userId: u.Id}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/person?id=6384", nil)
+	req = req.WithContext(context.WithValue(req.Context(), userCtxKey{}, u))
+	rec := httptest.NewRecorder()
+	handlePerson(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got personDetails
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Keanu Reeves" || len(got.Credits) != 2 {
+		t.Fatalf("person = %+v", got)
+	}
+	reloaded, matrix := got.Credits[0], got.Credits[1]
+	if reloaded.Id != 604 || !reloaded.Availability.Local || reloaded.Availability.Watched {
+		t.Errorf("newest credit = %+v", reloaded)
+	}
+	if matrix.Character != "Thomas A. Anderson / Neo" || len(matrix.Jobs) != 1 || !matrix.Availability.Watched || matrix.Availability.Local {
+		t.Errorf("merged credit = %+v", matrix)
+	}
+}
+
+func TestCollectionPartsInReleaseOrder(t *testing.T) {
+	useMetadata(t, fixtureProvider{dir: "testdata/metadata"})
+	useLibrary(t)
+	store = openTestStore(t)
+
+	var got collectionDetails
+	if code := getJSON(t, handleCollection, "/api/collection?id=2344", &got); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if len(got.Parts) != 2 || got.Parts[0].Id != 603 || got.Parts[1].Id != 604 {
+		t.Errorf("parts = %+v", got.Parts)
+	}
+	if code := getJSON(t, handleCollection, "/api/collection?id=1", nil); code != http.StatusNotFound {
+		t.Errorf("missing collection status = %d", code)
+	}
+
+	var movie movieDetails
+	getJSON(t, handleMovie, "/api/movie?id=603", &movie)
+	if movie.Collection == nil || movie.Collection.Id != 2344 {
+		t.Errorf("movie collection = %+v", movie.Collection)
+	}
+}
+
+func TestCompareDates(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1999-03-31", "2003-05-15", -1},
+		{"2003-05-15", "1999-03-31", 1},
+		{"", "1999-03-31", 1},
+		{"1999-03-31", "", -1},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := compareDates(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareDates(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
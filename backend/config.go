@@ -1,46 +1,528 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Port                  string
-	TorrentPort           int
-	DownloadDir           string
-	TorrentStorageLimitGB float64
-	TmdbApiKey            string
-	ProwlarrBaseUrl       string
-	ProwlarrApiKey        string
+	Port             string
+	TorrentPort      int
+	DownloadDir      string
+	DataDir          string
+	LibraryDir       string
+	MediaDirs        []string
+	MinFreeSpaceGB   float64
+	TmdbApiKey       string
+	MetadataFixtures string
+	AdminUsername    string
+	AdminPassword    string
+	WatchedThreshold float64
+	PrecacheWindow   string
+	PrecacheMB       int64
+	ImageCacheMB     int64
+	NotifyWebhookUrl string
+	NotifyNtfyUrl    string
+	NotifyNtfyToken  string
+	SmtpHost         string
+	SmtpPort         string
+	SmtpUsername     string
+	SmtpPassword     string
+	SmtpFrom         string
+	SmtpTo           []string
+	WebhookUrls      []string
+	WebhookSecret    string
+	MetricsToken     string
+	LogLevel         string
+	LogFormat        string
+
+	// Live holds the settings as loaded at startup. They can be reloaded
+	// while running, so read them through settings() instead.
+	Live liveSettings
+}
+
+// liveSettings can be changed without a restart, on SIGHUP or through the
+// admin settings API.
+type liveSettings struct {
+	StorageLimitGB     float64        `json:"storageLimitGB"`
+	SeedRatio          float64        `json:"seedRatio"`
+	TorrentTTL         duration       `json:"torrentTTL"`
+	PrecacheRetention  duration       `json:"precacheRetention"`
+	ReadaheadMB        int            `json:"readaheadMB"`
+	MaxConnsPerTorrent int            `json:"maxConnsPerTorrent"`
+	ProwlarrBaseUrl    string         `json:"prowlarrBaseUrl"`
+	ProwlarrApiKey     string         `json:"prowlarrApiKey"`
+	Language           string         `json:"language"`
+	Region             string         `json:"region"`
+	Trackers           []string       `json:"trackers"`
+	Quality            qualityProfile `json:"quality"`
+}
+
+var liveConfig atomic.Pointer[liveSettings]
+
+// settings returns the current hot-reloadable settings.
+func settings() liveSettings {
+	if s := liveConfig.Load(); s != nil {
+		return *s
+	}
+	return cfg.Live
+}
+
+// applySettings makes s the current settings and updates running torrents
+// whose connection limit or trackers changed.
+func applySettings(s liveSettings) {
+	old := liveConfig.Swap(&s)
+	if old != nil {
+		updateTorrentSettings(*old, s)
+	}
+}
+
+// duration is a time.Duration that reads and writes as "15m" in JSON.
+type duration struct {
+	time.Duration
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
 }
 
-func getEnv(key, fallback string) string {
+func (d *duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	d.Duration = v
+	return err
+}
+
+// ConfigError points at the key, and where its value came from, that failed
+// to parse or validate.
+type ConfigError struct {
+	Key    string
+	Source string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s (%s): %v", e.Key, e.Source, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// configLoader resolves keys in order of precedence: the environment, a
+// KEY_FILE path in the environment, then the config file. Parse and
+// validation errors are collected so all of them are reported at once.
+type configLoader struct {
+	file     map[string]string
+	filePath string
+	sources  map[string]string
+	errs     []error
+}
+
+func loadConfig() (Config, error) {
+	l := &configLoader{sources: map[string]string{}}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		file, err := readConfigFile(path)
+		if err != nil {
+			return Config{}, &ConfigError{Key: "CONFIG_FILE", Source: "env", Err: err}
+		}
+		l.file, l.filePath = file, path
+	}
+
+	c := Config{
+		Port:             l.str("PORT", "8080"),
+		TorrentPort:      l.int("TORRENT_PORT", 42069),
+		DownloadDir:      l.str("DOWNLOAD_DIR", "./downloads"),
+		DataDir:          l.str("DATA_DIR", "./data"),
+		LibraryDir:       l.str("LIBRARY_DIR", "./library"),
+		MediaDirs:        l.list("MEDIA_DIRS", nil),
+		MinFreeSpaceGB:   l.float("MIN_FREE_SPACE_GB", 1),
+		TmdbApiKey:       l.str("TMDB_API_KEY", ""),
+		MetadataFixtures: l.str("METADATA_FIXTURES", ""),
+		AdminUsername:    l.str("ADMIN_USERNAME", ""),
+		AdminPassword:    l.str("ADMIN_PASSWORD", ""),
+		WatchedThreshold: l.float("WATCHED_THRESHOLD", 0.9),
+		PrecacheWindow:   l.str("PRECACHE_WINDOW", ""),
+		PrecacheMB:       int64(l.int("PRECACHE_MB", 200)),
+		ImageCacheMB:     int64(l.int("IMAGE_CACHE_MB", 1024)),
+		NotifyWebhookUrl: l.str("NOTIFY_WEBHOOK_URL", ""),
+		NotifyNtfyUrl:    l.str("NOTIFY_NTFY_URL", ""),
+		NotifyNtfyToken:  l.str("NOTIFY_NTFY_TOKEN", ""),
+		SmtpHost:         l.str("SMTP_HOST", ""),
+		SmtpPort:         l.str("SMTP_PORT", "587"),
+		SmtpUsername:     l.str("SMTP_USERNAME", ""),
+		SmtpPassword:     l.str("SMTP_PASSWORD", ""),
+		SmtpFrom:         l.str("SMTP_FROM", ""),
+		SmtpTo:           l.list("SMTP_TO", nil),
+		WebhookUrls:      l.list("WEBHOOK_URLS", nil),
+		WebhookSecret:    l.str("WEBHOOK_SECRET", ""),
+		MetricsToken:     l.str("METRICS_TOKEN", ""),
+		LogLevel:         l.str("LOG_LEVEL", "info"),
+		LogFormat:        l.str("LOG_FORMAT", "text"),
+		Live: liveSettings{
+			StorageLimitGB:     l.float("TORRENT_STORAGE_LIMIT_GB", 50),
+			SeedRatio:          l.float("SEED_RATIO", 2.0),
+			TorrentTTL:         duration{l.duration("TORRENT_TTL", 15*time.Minute)},
+			PrecacheRetention:  duration{l.duration("PRECACHE_RETENTION", 24*time.Hour)},
+			ReadaheadMB:        l.int("READAHEAD_MB", 50),
+			MaxConnsPerTorrent: l.int("MAX_CONNS_PER_TORRENT", 500),
+			ProwlarrBaseUrl:    l.required("PROWLARR_BASE_URL"),
+			ProwlarrApiKey:     l.required("PROWLARR_API_KEY"),
+			Language:           l.str("TMDB_LANGUAGE", fallbackLanguage),
+			Region:             l.str("TMDB_REGION", ""),
+			Trackers:           l.list("TRACKERS", defaultTrackerList()),
+			Quality: qualityProfile{
+				PreferredResolution: l.int("QUALITY_PREFERRED_RESOLUTION", 1080),
+				MaxResolution:       l.int("QUALITY_MAX_RESOLUTION", 2160),
+				MinSeeders:          l.int("QUALITY_MIN_SEEDERS", 5),
+				MaxSizeGB:           l.float("QUALITY_MAX_SIZE_GB", 0),
+			},
+		},
+	}
+
+	l.validate(c)
+	return c, errors.Join(l.errs...)
+}
+
+func (l *configLoader) validate(c Config) {
+	l.check("TORRENT_PORT", c.TorrentPort < 1 || c.TorrentPort > 65535, "must be between 1 and 65535")
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		l.fail("PORT", errors.New("must be a port number between 1 and 65535"))
+	}
+	l.check("MIN_FREE_SPACE_GB", c.MinFreeSpaceGB < 0, "must not be negative")
+	// Fixtures replace TMDB, so the key is only needed without them.
+	l.check("TMDB_API_KEY", c.TmdbApiKey == "" && c.MetadataFixtures == "", "is required")
+	l.check("WATCHED_THRESHOLD", c.WatchedThreshold <= 0 || c.WatchedThreshold > 1, "must be greater than 0 and at most 1")
+	l.check("PRECACHE_MB", c.PrecacheMB < 0, "must not be negative")
+	l.check("IMAGE_CACHE_MB", c.ImageCacheMB < 0, "must not be negative")
+	if c.PrecacheWindow != "" {
+		if _, err := parsePrecacheWindow(c.PrecacheWindow); err != nil {
+			l.fail("PRECACHE_WINDOW", err)
+		}
+	}
+	if _, err := strconv.Atoi(c.SmtpPort); err != nil {
+		l.fail("SMTP_PORT", errors.New("must be a port number"))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		l.fail("LOG_LEVEL", errors.New("must be one of debug, info, warn or error"))
+	}
+	format := strings.ToLower(c.LogFormat)
+	l.check("LOG_FORMAT", format != "text" && format != "json", "must be text or json")
+
+	liveErrs := c.Live.validate()
+	for _, key := range slices.Sorted(maps.Keys(liveErrs)) {
+		l.fail(key, liveErrs[key])
+	}
+}
+
+// validate returns an error per offending key.
+func (s liveSettings) validate() map[string]error {
+	errs := map[string]error{}
+	if s.StorageLimitGB <= 0 {
+		errs["TORRENT_STORAGE_LIMIT_GB"] = errors.New("must be greater than 0")
+	}
+	if s.SeedRatio < 0 {
+		errs["SEED_RATIO"] = errors.New("must not be negative, use 0 to seed without a ratio limit")
+	}
+	if s.TorrentTTL.Duration <= 0 {
+		errs["TORRENT_TTL"] = errors.New("must be a positive duration")
+	}
+	if s.PrecacheRetention.Duration < 0 {
+		errs["PRECACHE_RETENTION"] = errors.New("must not be negative")
+	}
+	if s.ReadaheadMB < 0 {
+		errs["READAHEAD_MB"] = errors.New("must not be negative")
+	}
+	if s.MaxConnsPerTorrent < 1 {
+		errs["MAX_CONNS_PER_TORRENT"] = errors.New("must be at least 1")
+	}
+	if u, err := url.Parse(s.ProwlarrBaseUrl); s.ProwlarrBaseUrl != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+		errs["PROWLARR_BASE_URL"] = errors.New("must be an absolute URL")
+	}
+	if !languageRegex.MatchString(s.Language) {
+		errs["TMDB_LANGUAGE"] = errors.New("must look like \"en\" or \"en-US\"")
+	}
+	if s.Region != "" && !regionRegex.MatchString(s.Region) {
+		errs["TMDB_REGION"] = errors.New("must be a two letter country code like \"US\"")
+	}
+	for _, tr := range s.Trackers {
+		if u, err := url.Parse(tr); err != nil || u.Scheme == "" || u.Host == "" {
+			errs["TRACKERS"] = fmt.Errorf("invalid tracker URL %q", tr)
+			break
+		}
+	}
+	if s.Quality.PreferredResolution <= 0 {
+		errs["QUALITY_PREFERRED_RESOLUTION"] = errors.New("must be greater than 0")
+	}
+	if s.Quality.MaxResolution != 0 && s.Quality.MaxResolution < s.Quality.PreferredResolution {
+		errs["QUALITY_MAX_RESOLUTION"] = errors.New("must be 0 or at least the preferred resolution")
+	}
+	if s.Quality.MinSeeders < 0 {
+		errs["QUALITY_MIN_SEEDERS"] = errors.New("must not be negative")
+	}
+	if s.Quality.MaxSizeGB < 0 {
+		errs["QUALITY_MAX_SIZE_GB"] = errors.New("must not be negative")
+	}
+	return errs
+}
+
+func (l *configLoader) lookup(key string) (string, bool) {
 	if v := os.Getenv(key); v != "" {
+		l.sources[key] = "env"
+		return v, true
+	}
+
+	secretPath := os.Getenv(key + "_FILE")
+	source := "env " + key + "_FILE"
+	if secretPath == "" && l.file != nil {
+		secretPath = l.file[key+"_FILE"]
+		source = l.filePath + " " + strings.ToLower(key) + "_file"
+	}
+	if secretPath != "" {
+		l.sources[key] = source
+		b, err := os.ReadFile(secretPath)
+		if err != nil {
+			l.fail(key, err)
+			return "", false
+		}
+		return strings.TrimSpace(string(b)), true
+	}
+
+	if v, ok := l.file[key]; ok && v != "" {
+		l.sources[key] = l.filePath
+		return v, true
+	}
+	l.sources[key] = "default"
+	return "", false
+}
+
+func (l *configLoader) fail(key string, err error) {
+	source := l.sources[key]
+	if source == "" {
+		source = "default"
+	}
+	l.errs = append(l.errs, &ConfigError{Key: key, Source: source, Err: err})
+}
+
+func (l *configLoader) check(key string, invalid bool, msg string) {
+	if invalid {
+		l.fail(key, errors.New(msg))
+	}
+}
+
+func (l *configLoader) str(key, fallback string) string {
+	if v, ok := l.lookup(key); ok {
 		return v
 	}
 	return fallback
 }
 
-func requireEnv(key string) string {
-	v := os.Getenv(key)
-	if v == "" {
-		panic(fmt.Sprintf("missing required environment variable: %s", key))
+func (l *configLoader) required(key string) string {
+	v, ok := l.lookup(key)
+	if !ok {
+		l.fail(key, errors.New("is required"))
 	}
 	return v
 }
 
-func loadConfig() Config {
-	torrentPort, _ := strconv.Atoi(getEnv("TORRENT_PORT", "42069"))
-	storageLimitGB, _ := strconv.ParseFloat(getEnv("TORRENT_STORAGE_LIMIT_GB", "50"), 64)
-	return Config{
-		Port:                  getEnv("PORT", "8080"),
-		TorrentPort:           torrentPort,
-		DownloadDir:           getEnv("DOWNLOAD_DIR", "./downloads"),
-		TorrentStorageLimitGB: storageLimitGB,
-		TmdbApiKey:            requireEnv("TMDB_API_KEY"),
-		ProwlarrBaseUrl:       requireEnv("PROWLARR_BASE_URL"),
-		ProwlarrApiKey:        requireEnv("PROWLARR_API_KEY"),
+func (l *configLoader) int(key string, fallback int) int {
+	v, ok := l.lookup(key)
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		l.fail(key, fmt.Errorf("invalid integer %q", v))
+		return fallback
+	}
+	return n
+}
+
+func (l *configLoader) float(key string, fallback float64) float64 {
+	v, ok := l.lookup(key)
+	if !ok {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		l.fail(key, fmt.Errorf("invalid number %q", v))
+		return fallback
+	}
+	return f
+}
+
+func (l *configLoader) duration(key string, fallback time.Duration) time.Duration {
+	v, ok := l.lookup(key)
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.fail(key, fmt.Errorf("invalid duration %q, use e.g. 15m or 1h30m", v))
+		return fallback
+	}
+	return d
+}
+
+func (l *configLoader) list(key string, fallback []string) []string {
+	if v, ok := l.lookup(key); ok {
+		return splitList(v)
+	}
+	return fallback
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// readConfigFile parses a YAML or TOML file, chosen by extension. Keys are
+// the lower case environment variable names, nested tables are joined with
+// underscores so that
+//
+//	quality:
+//	  min_seeders: 10
+//
+// sets QUALITY_MIN_SEEDERS. Lists become comma separated values.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q, use .yaml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := map[string]string{}
+	flattenConfig("", raw, out)
+	return out, nil
+}
+
+func flattenConfig(prefix string, m map[string]any, out map[string]string) {
+	for k, v := range m {
+		key := strings.ToUpper(k)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			flattenConfig(key, v, out)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, ",")
+		case nil:
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// reloadConfig re-reads the environment and config file and applies the live
+// settings, with the overrides from the settings API on top. It returns the
+// names of other settings that changed, which only take effect after a
+// restart.
+func reloadConfig() (liveSettings, []string, error) {
+	next, err := loadConfig()
+	if err != nil {
+		return liveSettings{}, nil, err
+	}
+	s, err := setBaseSettings(next.Live)
+	if err != nil {
+		return liveSettings{}, nil, err
+	}
+	return s, restartRequired(cfg, next), nil
+}
+
+func restartRequired(old, next Config) []string {
+	var changed []string
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(next)
+	for i := 0; i < ov.NumField(); i++ {
+		name := ov.Type().Field(i).Name
+		if name == "Live" {
+			continue
+		}
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// reloadAndLog reloads the configuration and logs what changed.
+func reloadAndLog() (liveSettings, []string, error) {
+	s, restart, err := reloadConfig()
+	if err != nil {
+		return s, nil, err
+	}
+	slog.Info("Configuration reloaded", "storage_limit_gb", s.StorageLimitGB, "seed_ratio", s.SeedRatio, "torrent_ttl", s.TorrentTTL.String(), "trackers", len(s.Trackers))
+	if len(restart) > 0 {
+		slog.Warn("Changed settings take effect after a restart", "settings", restart)
+	}
+	return s, restart, nil
+}
+
+func logConfigErrors(err error) {
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	} else {
+		errs = []error{err}
+	}
+	for _, e := range errs {
+		var ce *ConfigError
+		if errors.As(e, &ce) {
+			slog.Error("Invalid configuration", "key", ce.Key, "source", ce.Source, "err", ce.Err)
+		} else {
+			slog.Error("Invalid configuration", "err", e)
+		}
+	}
+}
+
+func handleReloadConfig(w http.ResponseWriter, r *http.Request) {
+	s, restart, err := reloadAndLog()
+	if err != nil {
+		logConfigErrors(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if restart == nil {
+		restart = []string{}
 	}
+	writeJSON(w, map[string]any{
+		"settings":        s,
+		"restartRequired": restart,
+	})
 }
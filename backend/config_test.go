@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func setRequiredEnv(t *testing.T) {
+	t.Setenv("TMDB_API_KEY", "tmdb")
+	t.Setenv("PROWLARR_BASE_URL", "http://prowlarr:9696")
+	t.Setenv("PROWLARR_API_KEY", "prowlarr")
+}
+
+func TestLoadConfigLayersFileUnderEnv(t *testing.T) {
+	setRequiredEnv(t)
+	secret := writeFile(t, "secret", "s3cret\n")
+	t.Setenv("CONFIG_FILE", writeFile(t, "kiroshi.yaml", `
+port: 9000
+torrent_port: 50000
+torrent_ttl: 1h
+trackers:
+  - udp://tracker.example:1337/announce
+quality:
+  min_seeders: 12
+  max_size_gb: 8.5
+webhook_secret_file: `+secret+`
+`))
+	t.Setenv("PORT", "7000")
+
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != "7000" {
+		t.Errorf("Port = %q, env should win over the file", c.Port)
+	}
+	if c.TorrentPort != 50000 {
+		t.Errorf("TorrentPort = %d", c.TorrentPort)
+	}
+	if c.Live.TorrentTTL.Duration != time.Hour {
+		t.Errorf("TorrentTTL = %v", c.Live.TorrentTTL)
+	}
+	if len(c.Live.Trackers) != 1 || c.Live.Trackers[0] != "udp://tracker.example:1337/announce" {
+		t.Errorf("Trackers = %v", c.Live.Trackers)
+	}
+	if c.Live.Quality.MinSeeders != 12 || c.Live.Quality.MaxSizeGB != 8.5 {
+		t.Errorf("Quality = %+v", c.Live.Quality)
+	}
+	if c.WebhookSecret != "s3cret" {
+		t.Errorf("WebhookSecret = %q, want the contents of the secret file", c.WebhookSecret)
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CONFIG_FILE", writeFile(t, "kiroshi.toml", `
+seed_ratio = 1.5
+
+[quality]
+preferred_resolution = 720
+`))
+
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Live.SeedRatio != 1.5 || c.Live.Quality.PreferredResolution != 720 {
+		t.Errorf("Live = %+v", c.Live)
+	}
+}
+
+func TestLoadConfigReportsEveryInvalidKey(t *testing.T) {
+	t.Setenv("PROWLARR_BASE_URL", "http://prowlarr:9696")
+	t.Setenv("PROWLARR_API_KEY", "prowlarr")
+	t.Setenv("TORRENT_PORT", "abc")
+	t.Setenv("CONFIG_FILE", writeFile(t, "kiroshi.yml", "watched_threshold: 2\n"))
+
+	_, err := loadConfig()
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+
+	got := map[string]string{}
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var ce *ConfigError
+		if !errors.As(e, &ce) {
+			t.Fatalf("untyped error %v", e)
+		}
+		got[ce.Key] = ce.Source
+	}
+	for key, source := range map[string]string{
+		"TMDB_API_KEY":      "default",
+		"TORRENT_PORT":      "env",
+		"WATCHED_THRESHOLD": os.Getenv("CONFIG_FILE"),
+	} {
+		if got[key] != source {
+			t.Errorf("%s: source %q, want %q (all errors: %v)", key, got[key], source, err)
+		}
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := Config{Port: "8080", Live: liveSettings{SeedRatio: 2}}
+	next := Config{Port: "9090", Live: liveSettings{SeedRatio: 1}}
+	changed := restartRequired(old, next)
+	if len(changed) != 1 || changed[0] != "Port" {
+		t.Errorf("changed = %v, live settings should not require a restart", changed)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	// TMDB does not serve pages past 500.
+	maxDiscoverPage = 500
+	// becauseYouWatchedTitles is how many recently watched titles get a
+	// recommendation row.
+	becauseYouWatchedTitles = 3
+)
+
+type mediaPage struct {
+	Page         int            `json:"page"`
+	TotalPages   int            `json:"totalPages"`
+	TotalResults int            `json:"totalResults"`
+	Results      []mediaSummary `json:"results"`
+}
+
+type genre struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type recommendationRow struct {
+	Because mediaSummary   `json:"because"`
+	Results []mediaSummary `json:"results"`
+}
+
+// tmdbKind maps Kiroshi's media types to TMDB's path segment.
+func tmdbKind(mediaType string) (string, bool) {
+	switch mediaType {
+	case "movie":
+		return "movie", true
+	case "show":
+		return "tv", true
+	}
+	return "", false
+}
+
+func newMediaSummary(r tmdbSearchResult, kind string) mediaSummary {
+	if kind == "movie" {
+		return newMovieSummary(r)
+	}
+	return newShowSummary(r)
+}
+
+// newMediaPage converts a TMDB page. Results of mixed endpoints carry their
+// own media type, people are dropped.
+func newMediaPage(p tmdbPage, kind string) mediaPage {
+	out := mediaPage{Page: p.Page, TotalPages: min(p.TotalPages, maxDiscoverPage), TotalResults: p.TotalResults, Results: []mediaSummary{}}
+	for _, r := range p.Results {
+		k := kind
+		if r.MediaType != "" {
+			k = r.MediaType
+		}
+		if k != "movie" && k != "tv" {
+			continue
+		}
+		out.Results = append(out.Results, newMediaSummary(r, k))
+	}
+	return out
+}
+
+// discoverParams reads the type and page query parameters, writing a 400 and
+// returning false when they are invalid. allowAll accepts type=all.
+func discoverParams(w http.ResponseWriter, r *http.Request, defaultType string, allowAll bool) (string, int, bool) {
+	mediaType := r.URL.Query().Get("type")
+	if mediaType == "" {
+		mediaType = defaultType
+	}
+	kind, ok := tmdbKind(mediaType)
+	if !ok && allowAll && mediaType == "all" {
+		kind, ok = "all", true
+	}
+	if !ok {
+		http.Error(w, "Invalid type parameter", http.StatusBadRequest)
+		return "", 0, false
+	}
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 || n > maxDiscoverPage {
+			http.Error(w, fmt.Sprintf("page must be between 1 and %d", maxDiscoverPage), http.StatusBadRequest)
+			return "", 0, false
+		}
+		page = n
+	}
+	return kind, page, true
+}
+
+func writeMediaPage(w http.ResponseWriter, r *http.Request, kind string, p tmdbPage, err error) {
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+	writeJSON(w, newMediaPage(p, kind))
+}
+
+// handleTrending serves /api/discover/trending?type=all|movie|show&window=day|week.
+func handleTrending(w http.ResponseWriter, r *http.Request) {
+	kind, page, ok := discoverParams(w, r, "all", true)
+	if !ok {
+		return
+	}
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = "week"
+	}
+	if window != "day" && window != "week" {
+		http.Error(w, "Invalid window parameter", http.StatusBadRequest)
+		return
+	}
+	p, err := metadata.List(r.Context(), "trending/"+kind+"/"+window, page)
+	writeMediaPage(w, r, kind, p, err)
+}
+
+func handlePopular(w http.ResponseWriter, r *http.Request) {
+	kind, page, ok := discoverParams(w, r, "movie", false)
+	if !ok {
+		return
+	}
+	p, err := metadata.List(r.Context(), kind+"/popular", page)
+	writeMediaPage(w, r, kind, p, err)
+}
+
+func handleTopRated(w http.ResponseWriter, r *http.Request) {
+	kind, page, ok := discoverParams(w, r, "movie", false)
+	if !ok {
+		return
+	}
+	p, err := metadata.List(r.Context(), kind+"/top_rated", page)
+	writeMediaPage(w, r, kind, p, err)
+}
+
+func handleGenres(w http.ResponseWriter, r *http.Request) {
+	kind, _, ok := discoverParams(w, r, "movie", false)
+	if !ok {
+		return
+	}
+	genres, err := metadata.Genres(r.Context(), kind)
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+	out := []genre{}
+	for _, g := range genres {
+		out = append(out, genre{Id: g.Id, Name: g.Name})
+	}
+	writeJSON(w, out)
+}
+
+// handleGenre lists the most popular titles of a genre.
+func handleGenre(w http.ResponseWriter, r *http.Request) {
+	kind, page, ok := discoverParams(w, r, "movie", false)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid genre id", http.StatusBadRequest)
+		return
+	}
+	p, err := metadata.ByGenre(r.Context(), kind, id, page)
+	writeMediaPage(w, r, kind, p, err)
+}
+
+// recommendations returns TMDB's recommendations for a title, falling back to
+// similar titles for the long tail that has no recommendations.
+func recommendations(ctx context.Context, kind string, id, page int) (tmdbPage, error) {
+	p, err := metadata.List(ctx, fmt.Sprintf("%s/%d/recommendations", kind, id), page)
+	if err != nil || len(p.Results) > 0 || page > 1 {
+		return p, err
+	}
+	return metadata.List(ctx, fmt.Sprintf("%s/%d/similar", kind, id), page)
+}
+
+func handleRecommendations(w http.ResponseWriter, r *http.Request) {
+	kind, page, ok := discoverParams(w, r, "", false)
+	if !ok {
+		return
+	}
+	ids, ok := queryInts(w, r, "id")
+	if !ok {
+		return
+	}
+	p, err := recommendations(r.Context(), kind, ids[0], page)
+	writeMediaPage(w, r, kind, p, err)
+}
+
+// handleBecauseYouWatched returns a recommendation row for each of the most
+// recently watched movies and shows.
+func handleBecauseYouWatched(w http.ResponseWriter, r *http.Request) {
+	u, _ := currentUser(r)
+	history, err := store.ListProgress(u.Id)
+	if err != nil {
+		http.Error(w, "Failed to load progress", http.StatusInternalServerError)
+		return
+	}
+
+	type title struct {
+		kind string
+		id   int
+	}
+	var titles []title
+	seen := map[title]bool{}
+	for _, p := range history {
+		t := title{"movie", p.TmdbId}
+		if p.Type == "episode" {
+			t.kind = "tv"
+		}
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		titles = append(titles, t)
+		if len(titles) == becauseYouWatchedTitles {
+			break
+		}
+	}
+
+	rows := make([]*recommendationRow, len(titles))
+	var wg sync.WaitGroup
+	for i, t := range titles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := r.Context()
+
+			var because mediaSummary
+			if t.kind == "movie" {
+				m, err := metadata.Movie(ctx, t.id)
+				if err != nil {
+					slog.WarnContext(ctx, "Failed to fetch watched title", "movie", t.id, "err", err)
+					return
+				}
+				because = newMovieDetails(m).mediaSummary
+			} else {
+				s, err := metadata.Show(ctx, t.id)
+				if err != nil {
+					slog.WarnContext(ctx, "Failed to fetch watched title", "show", t.id, "err", err)
+					return
+				}
+				because = newShowDetails(s).mediaSummary
+			}
+
+			p, err := recommendations(ctx, t.kind, t.id, 1)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to fetch recommendations", "because", because.Title, "err", err)
+				return
+			}
+			rows[i] = &recommendationRow{Because: because, Results: newMediaPage(p, t.kind).Results}
+		}()
+	}
+	wg.Wait()
+
+	out := []recommendationRow{}
+	for _, row := range rows {
+		if row != nil && len(row.Results) > 0 {
+			out = append(out, *row)
+		}
+	}
+	writeJSON(w, out)
+}
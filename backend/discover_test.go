@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrendingMixesMoviesAndShows(t *testing.T) {
+	useTmdbServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/trending/all/day" || r.URL.Query().Get("page") != "2" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"page": 2, "total_pages": 900, "total_results": 18000, "results": [
+			{"id": 603, "media_type": "movie", "title": "The Matrix"},
+			{"id": 1399, "media_type": "tv", "name": "Game of Thrones"},
+			{"id": 6384, "media_type": "person", "name": "Keanu Reeves"}
+		]}`))
+	})
+
+	rec := httptest.NewRecorder()
+	handleTrending(rec, httptest.NewRequest(http.MethodGet, "/api/discover/trending?window=day&page=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got mediaPage
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Page != 2 || got.TotalPages != maxDiscoverPage {
+		t.Errorf("page = %d of %d", got.Page, got.TotalPages)
+	}
+	if len(got.Results) != 2 || got.Results[0].Type != "movie" || got.Results[1].Type != "show" || got.Results[1].Title != "Game of Thrones" {
+		t.Errorf("results = %+v", got.Results)
+	}
+
+	for _, target := range []string{
+		"/api/discover/trending?window=month",
+		"/api/discover/trending?page=501",
+		"/api/discover/trending?type=person",
+	} {
+		rec := httptest.NewRecorder()
+		handleTrending(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", target, rec.Code)
+		}
+	}
+}
+
+func TestRecommendationsFallBackToSimilar(t *testing.T) {
+	useTmdbServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tv/1399/recommendations":
+			w.Write([]byte(`{"page": 1, "total_pages": 0, "results": []}`))
+		case "/tv/1399/similar":
+			w.Write([]byte(`{"page": 1, "total_pages": 1, "results": [{"id": 1396, "name": "Breaking Bad"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	handleRecommendations(rec, httptest.NewRequest(http.MethodGet, "/api/discover/recommendations?type=show&id=1399", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got mediaPage
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Results) != 1 || got.Results[0].Id != 1396 || got.Results[0].Type != "show" {
+		t.Errorf("results = %+v", got.Results)
+	}
+}
+
+func TestBecauseYouWatched(t *testing.T) {
+	useTmdbServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tv/1399":
+			w.Write([]byte(`{"id": 1399, "name": "Game of Thrones"}`))
+		case "/tv/1399/recommendations":
+			w.Write([]byte(`{"page": 1, "results": [{"id": 1396, "name": "Breaking Bad"}]}`))
+		case "/movie/603":
+			w.Write([]byte(`{"id": 603, "title": "The Matrix"}`))
+		case "/movie/603/recommendations":
+			w.Write([]byte(`{"page": 1, "results": [{"id": 604, "title": "The Matrix Reloaded"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	store = openTestStore(t)
+	u, err := store.CreateUser("alice", "hash", roleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []progress{
+		{mediaKey: mediaKey{Type: "movie", TmdbId: 603}},
+		{mediaKey: mediaKey{Type: "episode", TmdbId: 1399, Season: 1, Episode: 1}},
+		{mediaKey: mediaKey{Type: "episode", TmdbId: 1399, Season: 1, Episode: 2}},
+	} {
+		p.userId = u.Id
+		if err := store.SaveProgress(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/discover/because-you-watched", nil)
+	req = req.WithContext(context.WithValue(req.Context(), userCtxKey{}, u))
+	rec := httptest.NewRecorder()
+	handleBecauseYouWatched(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got []recommendationRow
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("rows = %+v", got)
+	}
+	for _, row := range got {
+		switch row.Because.Id {
+		case 603:
+			if row.Results[0].Title != "The Matrix Reloaded" {
+				t.Errorf("movie row = %+v", row)
+			}
+		case 1399:
+			if row.Because.Type != "show" || row.Results[0].Title != "Breaking Bad" {
+				t.Errorf("show row = %+v", row)
+			}
+		default:
+			t.Errorf("unexpected row %+v", row)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	healthCheckTimeout = 5 * time.Second
+	// healthCacheTTL keeps frequent probes from hammering Prowlarr and TMDB.
+	healthCacheTTL = 15 * time.Second
+)
+
+type dependencyStatus struct {
+	Name      string  `json:"name"`
+	Healthy   bool    `json:"healthy"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type statusReport struct {
+	Healthy      bool               `json:"healthy"`
+	CheckedAt    time.Time          `json:"checkedAt"`
+	Dependencies []dependencyStatus `json:"dependencies"`
+}
+
+var errFreeSpaceUnsupported = errors.New("free space check not supported on this platform")
+
+var (
+	healthMu     sync.Mutex
+	healthCached statusReport
+)
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// healthChecks lists the dependencies, the metadata check is named after the
+// configured provider.
+func healthChecks() []healthCheck {
+	return []healthCheck{
+		{"torrent", checkTorrentClient},
+		{"storage", checkDownloadDir},
+		{"prowlarr", checkProwlarr},
+		{metadata.Name(), metadata.Check},
+	}
+}
+
+// checkDependencies runs every health check concurrently. Results are reused
+// for healthCacheTTL.
+func checkDependencies(ctx context.Context) statusReport {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	if time.Since(healthCached.CheckedAt) < healthCacheTTL {
+		return healthCached
+	}
+
+	// A probe that disconnects early must not leave failures in the cache.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), healthCheckTimeout)
+	defer cancel()
+
+	checks := healthChecks()
+	report := statusReport{Healthy: true, CheckedAt: time.Now(), Dependencies: make([]dependencyStatus, len(checks))}
+	var wg sync.WaitGroup
+	for i, hc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := hc.check(ctx)
+			status := dependencyStatus{
+				Name:      hc.name,
+				Healthy:   err == nil,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				status.Error = err.Error()
+			}
+			report.Dependencies[i] = status
+		}()
+	}
+	wg.Wait()
+
+	for _, d := range report.Dependencies {
+		if !d.Healthy {
+			report.Healthy = false
+		}
+	}
+	healthCached = report
+	return report
+}
+
+func checkTorrentClient(ctx context.Context) error {
+	if tClient == nil {
+		return errors.New("torrent client not started")
+	}
+	if len(tClient.ListenAddrs()) == 0 {
+		return errors.New("torrent client is not listening")
+	}
+	return nil
+}
+
+func checkDownloadDir(ctx context.Context) error {
+	if err := os.MkdirAll(cfg.DownloadDir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(cfg.DownloadDir, ".healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("download directory is not writable: %w", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	free, err := freeSpace(cfg.DownloadDir)
+	if errors.Is(err, errFreeSpaceUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if min := uint64(cfg.MinFreeSpaceGB * 1024 * 1024 * 1024); free < min {
+		return fmt.Errorf("only %.1f GB free in download directory", float64(free)/1024/1024/1024)
+	}
+	return nil
+}
+
+func checkProwlarr(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(settings().ProwlarrBaseUrl, "/")+"/api/v1/system/status", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", settings().ProwlarrApiKey)
+	return checkResponse(prowlarrClient.Do(req))
+}
+
+func checkResponse(resp *http.Response, err error) error {
+	if err != nil {
+		// The URL in *url.Error may contain the TMDB API key.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return errors.New("API key rejected")
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// logDependencyStatus warns at startup about dependencies that are not
+// usable, e.g. a mistyped API key.
+func logDependencyStatus() {
+	report := checkDependencies(context.Background())
+	for _, d := range report.Dependencies {
+		if !d.Healthy {
+			slog.Warn("Dependency check failed", "dependency", d.Name, "err", d.Error)
+		}
+	}
+}
+
+// handleHealthz reports liveness, the process is up and serving requests.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether Kiroshi can serve streams, failing with 503
+// when any dependency is unhealthy. The endpoint is public, so it only says
+// which checks failed; the errors are on /api/status.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := checkDependencies(r.Context())
+	if !report.Healthy {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, d := range report.Dependencies {
+			result := "ok"
+			if !d.Healthy {
+				result = "failed"
+			}
+			fmt.Fprintf(w, "%s: %s\n", d.Name, result)
+		}
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, checkDependencies(r.Context()))
+}
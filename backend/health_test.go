@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckDependencies(t *testing.T) {
+	prowlarr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/system/status" || r.Header.Get("X-Api-Key") != "good" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer prowlarr.Close()
+	tmdb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_key") != "good" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer tmdb.Close()
+
+	oldCfg, oldBase := cfg, tmdbBaseURL
+	t.Cleanup(func() {
+		cfg, tmdbBaseURL = oldCfg, oldBase
+		healthCached = statusReport{}
+	})
+	cfg = Config{
+		DownloadDir: t.TempDir(),
+		TmdbApiKey:  "bad",
+	}
+	useSettings(t, liveSettings{ProwlarrBaseUrl: prowlarr.URL, ProwlarrApiKey: "good"})
+	tmdbBaseURL = tmdb.URL
+	healthCached = statusReport{}
+
+	report := checkDependencies(context.Background())
+	if report.Healthy {
+		t.Fatal("report is healthy with a rejected TMDB key")
+	}
+
+	byName := map[string]dependencyStatus{}
+	for _, d := range report.Dependencies {
+		byName[d.Name] = d
+	}
+	if !byName["prowlarr"].Healthy || !byName["storage"].Healthy {
+		t.Errorf("prowlarr or storage unhealthy: %+v", report.Dependencies)
+	}
+	if byName["torrent"].Healthy {
+		t.Error("torrent check passed without a client")
+	}
+	if d := byName["tmdb"]; d.Healthy || d.Error != "API key rejected" {
+		t.Errorf("tmdb = %+v", d)
+	}
+
+	rec := httptest.NewRecorder()
+	handleReadyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	want := "torrent: failed\nstorage: ok\nprowlarr: ok\ntmdb: failed\n"
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != want {
+		t.Errorf("readyz = %d %q, want the checks without their errors", rec.Code, rec.Body.String())
+	}
+}
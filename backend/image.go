@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	maxImageBytes = 20 * 1024 * 1024
+	// TMDB image paths are content hashes, a path never changes its image.
+	imageCacheControl = "public, max-age=31536000, immutable"
+)
+
+var (
+	tmdbImageBaseURL = "https://image.tmdb.org/t/p"
+	imageClient      = newUpstreamClient("tmdb_image", 30*time.Second)
+	images           = &imageCache{}
+	imageFetches     singleflight.Group
+)
+
+var (
+	imageSizes = map[string]bool{
+		"w45": true, "w92": true, "w154": true, "w185": true, "w300": true, "w342": true,
+		"w500": true, "w780": true, "w1280": true, "h632": true, "original": true,
+	}
+	imageNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+\.(jpg|jpeg|png|webp|svg)$`)
+)
+
+var (
+	errImageNotFound = errors.New("image not found")
+	errImageTooLarge = errors.New("image too large")
+)
+
+// imageCache stores proxied TMDB images under dir/<size>/<name>. When the
+// cache grows past max bytes the least recently served files are removed,
+// file modification times record the last use.
+type imageCache struct {
+	mu       sync.Mutex
+	dir      string
+	max      int64
+	used     int64
+	evicting bool
+}
+
+func initImageCache() {
+	if cfg.ImageCacheMB == 0 {
+		slog.Info("Image cache disabled, images are proxied without caching")
+		return
+	}
+	dir := filepath.Join(cfg.DataDir, "image-cache")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		slog.Warn("Image cache disabled", "err", err)
+		return
+	}
+
+	var used int64
+	for _, f := range imageFiles(dir) {
+		used += f.size
+	}
+	images.mu.Lock()
+	images.dir = dir
+	images.max = cfg.ImageCacheMB * 1024 * 1024
+	images.used = used
+	images.mu.Unlock()
+	images.evict()
+}
+
+type cachedImage struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func imageFiles(dir string) []cachedImage {
+	var files []cachedImage
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".tmp-") {
+			os.Remove(path)
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, cachedImage{path: path, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	return files
+}
+
+// evict removes the least recently used files until the cache is at 90% of
+// its limit. The directory is walked without holding c.mu, so image requests
+// are not held up, and only one eviction runs at a time.
+func (c *imageCache) evict() {
+	c.mu.Lock()
+	if c.used <= c.max || c.evicting {
+		c.mu.Unlock()
+		return
+	}
+	c.evicting = true
+	dir, used, target := c.dir, c.used, c.max/10*9
+	c.mu.Unlock()
+
+	files := imageFiles(dir)
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	var freed int64
+	removed := 0
+	for _, f := range files {
+		if used-freed <= target {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			freed += f.size
+			removed++
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.used -= freed
+	c.evicting = false
+	slog.Debug("Evicted cached images", "removed", removed, "used_bytes", c.used)
+}
+
+// added accounts for size more bytes on disk, which may be negative when a
+// file was replaced by a smaller one.
+func (c *imageCache) added(size int64) {
+	c.mu.Lock()
+	c.used += size
+	c.mu.Unlock()
+	c.evict()
+}
+
+func (c *imageCache) enabled() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dir, c.dir != ""
+}
+
+// fetchImage downloads a TMDB image. The body is limited to maxImageBytes.
+func fetchImage(ctx context.Context, size, name string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tmdbImageBaseURL+"/"+size+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errImageNotFound
+	case resp.StatusCode != http.StatusOK:
+		resp.Body.Close()
+		return nil, fmt.Errorf("TMDB returned %s", resp.Status)
+	case !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/"):
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	case resp.ContentLength > maxImageBytes:
+		resp.Body.Close()
+		return nil, errImageTooLarge
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, maxImageBytes+1), resp.Body}
+	return resp, nil
+}
+
+// downloadImage fetches an image into the cache directory.
+func downloadImage(ctx context.Context, dir, size, name string) error {
+	resp, err := fetchImage(ctx, size, name)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := os.MkdirAll(filepath.Join(dir, size), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(dir, size), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n > maxImageBytes {
+		return errImageTooLarge
+	}
+	// A request that missed the file just before another download finished
+	// fetches it again, only the difference to the replaced file is new.
+	dst := filepath.Join(dir, size, name)
+	var replaced int64
+	if info, err := os.Stat(dst); err == nil {
+		replaced = info.Size()
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	images.added(n - replaced)
+	return nil
+}
+
+func setImageHeaders(w http.ResponseWriter, size, name string) {
+	w.Header().Set("Cache-Control", imageCacheControl)
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%s"`, size, name))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// SVG logos are served from our origin, keep them from running scripts.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+}
+
+// handleImage serves /api/image/{size}/{path}, a same-origin proxy for
+// image.tmdb.org so clients never contact TMDB directly.
+func handleImage(w http.ResponseWriter, r *http.Request) {
+	size, name := r.PathValue("size"), r.PathValue("path")
+	if !imageSizes[size] || !imageNameRegex.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	dir, ok := images.enabled()
+	if !ok {
+		proxyImage(w, r, size, name)
+		return
+	}
+
+	path := filepath.Join(dir, size, name)
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Concurrent requests for one image share a download that outlives
+		// any single client.
+		_, err, _ = imageFetches.Do(size+"/"+name, func() (any, error) {
+			return nil, downloadImage(context.WithoutCancel(r.Context()), dir, size, name)
+		})
+		if err == nil {
+			f, err = os.Open(path)
+		}
+	} else if err == nil {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+	}
+	if err != nil {
+		imageError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		imageError(w, r, err)
+		return
+	}
+	setImageHeaders(w, size, name)
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+func proxyImage(w http.ResponseWriter, r *http.Request, size, name string) {
+	resp, err := fetchImage(r.Context(), size, name)
+	if err != nil {
+		imageError(w, r, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	// Buffered, so a truncated image is never served with cache headers.
+	body, err := io.ReadAll(resp.Body)
+	if err == nil && len(body) > maxImageBytes {
+		err = errImageTooLarge
+	}
+	if err != nil {
+		imageError(w, r, err)
+		return
+	}
+	setImageHeaders(w, size, name)
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.Write(body)
+}
+
+func imageError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errImageNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	slog.WarnContext(r.Context(), "Failed to load image", "path", r.URL.Path, "err", err)
+	http.Error(w, "Failed to load image", http.StatusBadGateway)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func setupImageTest(t *testing.T, maxMB int64) *atomic.Int32 {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		switch r.URL.Path {
+		case "/t/p/w500/missing.jpg":
+			http.NotFound(w, r)
+			return
+		case "/t/p/original/huge.jpg":
+			w.Header().Set("Content-Length", fmt.Sprint(maxImageBytes+1))
+			w.Write(make([]byte, maxImageBytes+1))
+			return
+		case "/t/p/original/huge-chunked.jpg":
+			// Flushing first leaves out Content-Length.
+			w.(http.Flusher).Flush()
+			w.Write(make([]byte, maxImageBytes+1))
+			return
+		}
+		w.Write(bytes.Repeat([]byte{0xff}, 600*1024))
+	}))
+	t.Cleanup(server.Close)
+
+	oldCfg, oldBase := cfg, tmdbImageBaseURL
+	t.Cleanup(func() {
+		cfg, tmdbImageBaseURL = oldCfg, oldBase
+		images = &imageCache{}
+	})
+	cfg = Config{DataDir: t.TempDir(), ImageCacheMB: maxMB}
+	tmdbImageBaseURL = server.URL + "/t/p"
+	images = &imageCache{}
+	initImageCache()
+	return &calls
+}
+
+func getImage(path string, header http.Header) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/image/{size}/{path}", handleImage)
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestImageProxyCachesOnDisk(t *testing.T) {
+	calls := setupImageTest(t, 10)
+
+	rec := getImage("/api/image/w500/abc.jpg", nil)
+	if rec.Code != http.StatusOK || rec.Body.Len() != 600*1024 {
+		t.Fatalf("status = %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if got := rec.Header().Get("Cache-Control"); got != imageCacheControl {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "image/jpeg" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	rec = getImage("/api/image/w500/abc.jpg", http.Header{"If-None-Match": {rec.Header().Get("ETag")}})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d", rec.Code)
+	}
+	if rec := getImage("/api/image/w500/abc.jpg", nil); rec.Code != http.StatusOK {
+		t.Errorf("cached status = %d", rec.Code)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("TMDB called %d times, want 1", n)
+	}
+
+	if rec := getImage("/api/image/w500/missing.jpg", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("missing image status = %d", rec.Code)
+	}
+	for _, path := range []string{"/api/image/w9999/abc.jpg", "/api/image/w500/..%2Fsecret.jpg", "/api/image/w500/abc.html"} {
+		if rec := getImage(path, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s status = %d", path, rec.Code)
+		}
+	}
+}
+
+func TestImageCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	setupImageTest(t, 1)
+
+	getImage("/api/image/w500/old.jpg", nil)
+	old := filepath.Join(images.dir, "w500", "old.jpg")
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(old, past, past)
+
+	getImage("/api/image/w500/new.jpg", nil)
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("least recently used image not evicted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(images.dir, "w500", "new.jpg")); err != nil {
+		t.Errorf("new image evicted: %v", err)
+	}
+}
+
+func TestImageCacheCountsRedownloadsOnce(t *testing.T) {
+	setupImageTest(t, 10)
+
+	for range 2 {
+		if err := downloadImage(context.Background(), images.dir, "w500", "abc.jpg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	images.mu.Lock()
+	defer images.mu.Unlock()
+	if images.used != 600*1024 {
+		t.Errorf("used = %d, want %d", images.used, 600*1024)
+	}
+}
+
+func TestImageProxyRejectsOversizedImages(t *testing.T) {
+	for _, maxMB := range []int64{0, 100} {
+		setupImageTest(t, maxMB)
+		for _, path := range []string{"/api/image/original/huge.jpg", "/api/image/original/huge-chunked.jpg"} {
+			rec := getImage(path, nil)
+			if rec.Code != http.StatusBadGateway || rec.Header().Get("Cache-Control") == imageCacheControl {
+				t.Errorf("cache %d MB, %s: status = %d, Cache-Control %q", maxMB, path, rec.Code, rec.Header().Get("Cache-Control"))
+			}
+		}
+	}
+}
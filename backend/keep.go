@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+var (
+	keepPollInterval = 5 * time.Second
+	// keepStallTimeout fails a keep job whose download made no progress for
+	// that long, e.g. a torrent without seeders.
+	keepStallTimeout = 30 * time.Minute
+
+	keepJobsMu sync.Mutex
+	keepJobs   = map[string]*keepJob{}
+
+	unsafeNameChars = strings.NewReplacer("<", "", ">", "", ":", "", "\"", "", "/", "", "\\", "", "|", "", "?", "", "*", "")
+)
+
+type keepRequest struct {
+	Hash    string `json:"hash"`
+	FileIdx int    `json:"fileIdx"`
+	Type    string `json:"type"`
+	TmdbId  string `json:"tmdbId"`
+	Season  int    `json:"season,omitempty"`
+	Episode int    `json:"episode,omitempty"`
+}
+
+type keepJob struct {
+	Hash        string  `json:"hash"`
+	FileIdx     int     `json:"fileIdx"`
+	FileName    string  `json:"fileName"`
+	Destination string  `json:"destination"`
+	Status      string  `json:"status"`
+	Progress    float64 `json:"progress"`
+	Error       string  `json:"error,omitempty"`
+
+	file *torrent.File
+}
+
+func handleKeep(w http.ResponseWriter, r *http.Request) {
+	var req keepRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var ih metainfo.Hash
+	if err := ih.FromHexString(req.Hash); err != nil {
+		http.Error(w, "Invalid infohash", http.StatusBadRequest)
+		return
+	}
+
+	t, ok := tClient.Torrent(ih)
+	if !ok {
+		http.Error(w, "Torrent not found", http.StatusNotFound)
+		return
+	}
+
+	files := t.Files()
+	if req.FileIdx < 0 || req.FileIdx >= len(files) {
+		http.Error(w, "File index out of bounds", http.StatusNotFound)
+		return
+	}
+	file := files[req.FileIdx]
+
+	dest, err := libraryPath(r.Context(), req, filepath.Ext(file.Path()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := fmt.Sprintf("%s/%d", ih.HexString(), req.FileIdx)
+
+	keepJobsMu.Lock()
+	job, exists := keepJobs[key]
+	if !exists || job.Status == "failed" {
+		job = &keepJob{
+			Hash:        ih.HexString(),
+			FileIdx:     req.FileIdx,
+			FileName:    file.DisplayPath(),
+			Destination: dest,
+			Status:      "downloading",
+			file:        file,
+		}
+		keepJobs[key] = job
+		go runKeepJob(job)
+	}
+	snapshot := job.snapshot()
+	keepJobsMu.Unlock()
+
+	slog.InfoContext(r.Context(), "Keeping file", "file", file.DisplayPath(), "dest", dest)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+func handleKeepStatus(w http.ResponseWriter, r *http.Request) {
+	keepJobsMu.Lock()
+	jobs := make([]keepJob, 0, len(keepJobs))
+	for _, job := range keepJobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	keepJobsMu.Unlock()
+
+	writeJSON(w, jobs)
+}
+
+// snapshot must be called with keepJobsMu held.
+func (j *keepJob) snapshot() keepJob {
+	s := *j
+	if s.Status == "downloading" && j.file.Length() > 0 {
+		s.Progress = float64(j.file.BytesCompleted()) / float64(j.file.Length())
+	}
+	if s.Status == "done" {
+		s.Progress = 1
+	}
+	s.file = nil
+	return s
+}
+
+func runKeepJob(job *keepJob) {
+	file := job.file
+	t := file.Torrent()
+
+	// Holding a stream reference keeps cleanupRoutine away from the torrent
+	// until the file has been fully downloaded and linked into the library.
+	acquireStream(job.Hash)
+	defer releaseStream(job.Hash)
+
+	if file.Priority() < torrent.PiecePriorityNormal {
+		file.Download()
+	}
+
+	err := waitFileComplete(t, file)
+	if err == nil {
+		src := filepath.Join(cfg.DownloadDir, filepath.FromSlash(file.Path()))
+		err = linkOrCopy(src, job.Destination)
+	}
+
+	keepJobsMu.Lock()
+	defer keepJobsMu.Unlock()
+	if err != nil {
+		slog.Error("Failed to keep file", "file", job.FileName, "err", err)
+		job.Status = "failed"
+		job.Error = err.Error()
+		return
+	}
+	slog.Info("Kept file", "file", job.FileName, "dest", job.Destination)
+	job.Status = "done"
+	go scanLibrary()
+}
+
+func waitFileComplete(t *torrent.Torrent, file *torrent.File) error {
+	ticker := time.NewTicker(keepPollInterval)
+	defer ticker.Stop()
+
+	completed, lastProgress := file.BytesCompleted(), time.Now()
+	for completed < file.Length() {
+		select {
+		case <-ticker.C:
+		case <-t.Closed():
+			return errors.New("torrent was dropped before the download completed")
+		}
+		if n := file.BytesCompleted(); n != completed {
+			completed, lastProgress = n, time.Now()
+		} else if time.Since(lastProgress) >= keepStallTimeout {
+			return fmt.Errorf("download stalled for %s", keepStallTimeout)
+		}
+	}
+	return nil
+}
+
+// linkFile is os.Link, tests replace it to exercise the copy fallback.
+var linkFile = os.Link
+
+// linkOrCopy hardlinks src to dst so the torrent can keep seeding from the
+// original file. When both paths are on different filesystems it falls back
+// to a full copy. An existing dst is kept only if it is the same file, a copy
+// or any other file is replaced since its contents cannot be trusted.
+func linkOrCopy(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if dstInfo, err := os.Stat(dst); err == nil && os.SameFile(srcInfo, dstInfo) {
+		return nil
+	}
+
+	// Both the link and the copy are renamed over dst, so a stale file is
+	// replaced and dst is never seen half written.
+	tmp := dst + ".part"
+	os.Remove(tmp)
+	if err := linkFile(src, tmp); err == nil {
+		return os.Rename(tmp, dst)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// libraryPath builds a Plex/Jellyfin compatible destination for a kept file:
+//
+//	Movies/Title (Year)/Title (Year).mkv
+//	Shows/Title/Season 01/Title - S01E01.mkv
+//
+// Titles are the English ones, so the path is the same whoever keeps the file
+// and media servers match it.
+func libraryPath(ctx context.Context, req keepRequest, ext string) (string, error) {
+	if req.TmdbId == "" {
+		return "", errors.New("missing tmdbId")
+	}
+
+	tmdbId, err := strconv.Atoi(req.TmdbId)
+	if err != nil {
+		return "", errors.New("invalid tmdbId")
+	}
+
+	switch req.Type {
+	case "movie":
+		movie, err := metadata.Movie(englishContext(ctx), tmdbId)
+		if err != nil {
+			return "", err
+		}
+		title, releaseDate := movie.Title, movie.ReleaseDate
+		if title == "" {
+			return "", errors.New("movie not found on TMDB")
+		}
+		name := sanitizeFileName(title)
+		if len(releaseDate) >= 4 {
+			name = fmt.Sprintf("%s (%s)", name, releaseDate[:4])
+		}
+		return filepath.Join(cfg.LibraryDir, "Movies", name, name+ext), nil
+	case "episode":
+		if req.Season <= 0 || req.Episode <= 0 {
+			return "", errors.New("missing season or episode")
+		}
+		show, err := metadata.Show(englishContext(ctx), tmdbId)
+		if err != nil {
+			return "", err
+		}
+		title := show.Name
+		if title == "" {
+			return "", errors.New("show not found on TMDB")
+		}
+		name := sanitizeFileName(title)
+		return filepath.Join(
+			cfg.LibraryDir,
+			"Shows",
+			name,
+			fmt.Sprintf("Season %02d", req.Season),
+			fmt.Sprintf("%s - S%02dE%02d%s", name, req.Season, req.Episode, ext),
+		), nil
+	default:
+		return "", errors.New("invalid type")
+	}
+}
+
+func sanitizeFileName(name string) string {
+	return strings.Trim(unsafeNameChars.Replace(name), " .")
+}
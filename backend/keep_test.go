@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestLibraryPath(t *testing.T) {
+	useMetadata(t, fixtureProvider{dir: "testdata/metadata"})
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+	cfg = Config{LibraryDir: "/library"}
+
+	tests := []struct {
+		req  keepRequest
+		want string
+	}{
+		{keepRequest{Type: "movie", TmdbId: "603"}, "/library/Movies/The Matrix (1999)/The Matrix (1999).mkv"},
+		{keepRequest{Type: "episode", TmdbId: "1399", Season: 1, Episode: 2}, "/library/Shows/Game of Thrones/Season 01/Game of Thrones - S01E02.mkv"},
+	}
+	for _, tt := range tests {
+		got, err := libraryPath(context.Background(), tt.req, ".mkv")
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("libraryPath(%+v) = %q, %v, want %q", tt.req, got, err, tt.want)
+		}
+	}
+
+	for _, req := range []keepRequest{
+		{Type: "movie"},
+		{Type: "movie", TmdbId: "abc"},
+		{Type: "movie", TmdbId: "1"},
+		{Type: "episode", TmdbId: "1399"},
+		{Type: "show", TmdbId: "1399"},
+	} {
+		if got, err := libraryPath(context.Background(), req, ".mkv"); err == nil {
+			t.Errorf("libraryPath(%+v) = %q, want an error", req, got)
+		}
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := map[string]string{
+		"Mission: Impossible":   "Mission Impossible",
+		"What If...?":           "What If",
+		"AC/DC: Let There Be *": "ACDC Let There Be",
+	}
+	for in, want := range tests {
+		if got := sanitizeFileName(in); got != want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLinkOrCopy(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "downloads", "Movie.2020.1080p.mkv")
+	os.MkdirAll(filepath.Dir(src), 0o755)
+	if err := os.WriteFile(src, []byte("complete video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srcInfo, _ := os.Stat(src)
+
+	check := func(dst string, wantLink bool) {
+		t.Helper()
+		data, err := os.ReadFile(dst)
+		if err != nil || string(data) != "complete video" {
+			t.Fatalf("%s = %q, %v", dst, data, err)
+		}
+		info, _ := os.Stat(dst)
+		if linked := os.SameFile(srcInfo, info); linked != wantLink {
+			t.Errorf("%s hard linked = %v, want %v", dst, linked, wantLink)
+		}
+		if _, err := os.Stat(dst + ".part"); !os.IsNotExist(err) {
+			t.Errorf("temporary file left behind: %v", err)
+		}
+	}
+
+	linked := filepath.Join(dir, "library", "Movies", "Movie (2020)", "Movie (2020).mkv")
+	if err := linkOrCopy(src, linked); err != nil {
+		t.Fatal(err)
+	}
+	check(linked, true)
+	if err := linkOrCopy(src, linked); err != nil {
+		t.Errorf("keeping the same file again: %v", err)
+	}
+
+	// A partial file from an earlier attempt is replaced.
+	stale := filepath.Join(dir, "library", "stale.mkv")
+	os.WriteFile(stale, []byte("compl"), 0o644)
+	if err := linkOrCopy(src, stale); err != nil {
+		t.Fatal(err)
+	}
+	check(stale, true)
+
+	// So is a different file that happens to have the same size.
+	sameSize := filepath.Join(dir, "library", "same-size.mkv")
+	os.WriteFile(sameSize, []byte("partial video!"), 0o644)
+	if err := linkOrCopy(src, sameSize); err != nil {
+		t.Fatal(err)
+	}
+	check(sameSize, true)
+
+	// Across filesystems the link fails and the file is copied.
+	oldLink := linkFile
+	t.Cleanup(func() { linkFile = oldLink })
+	linkFile = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.New("invalid cross-device link")}
+	}
+	copied := filepath.Join(dir, "other", "copied.mkv")
+	if err := linkOrCopy(src, copied); err != nil {
+		t.Fatal(err)
+	}
+	check(copied, false)
+
+	if err := linkOrCopy(filepath.Join(dir, "missing.mkv"), filepath.Join(dir, "library", "missing.mkv")); err == nil {
+		t.Error("expected an error for a missing source")
+	}
+}
+
+func TestKeepFailsStalledDownload(t *testing.T) {
+	useTorrentClient(t)
+	useMetadata(t, fixtureProvider{dir: "testdata/metadata"})
+	oldCfg, oldPoll, oldStall := cfg, keepPollInterval, keepStallTimeout
+	t.Cleanup(func() {
+		cfg, keepPollInterval, keepStallTimeout = oldCfg, oldPoll, oldStall
+		keepJobsMu.Lock()
+		clear(keepJobs)
+		keepJobsMu.Unlock()
+	})
+	cfg.LibraryDir = t.TempDir()
+	keepPollInterval, keepStallTimeout = 10*time.Millisecond, 50*time.Millisecond
+
+	// A torrent whose data is nowhere and that has no peers never completes.
+	path := filepath.Join(t.TempDir(), "Missing.2021.1080p.mkv")
+	os.WriteFile(path, []byte(strings.Repeat("y", 1<<16)), 0o644)
+	info := metainfo.Info{PieceLength: 1 << 14}
+	if err := info.BuildFromFilePath(path); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, _ := bencode.Marshal(info)
+	tor, err := tClient.AddTorrent(&metainfo.MetaInfo{InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := tor.InfoHash().HexString()
+
+	rec := httptest.NewRecorder()
+	body := `{"hash": "` + hash + `", "fileIdx": 0, "type": "movie", "tmdbId": "603"}`
+	handleKeep(rec, httptest.NewRequest(http.MethodPost, "/api/keep", strings.NewReader(body)))
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, Content-Type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		keepJobsMu.Lock()
+		job := keepJobs[hash+"/0"].snapshot()
+		keepJobsMu.Unlock()
+		if job.Status == "failed" {
+			if !strings.Contains(job.Error, "stalled") {
+				t.Errorf("error = %q", job.Error)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hasActiveStreams(hash) {
+		t.Error("failed keep job still holds a stream reference")
+	}
+}
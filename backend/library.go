@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	libraryScanInterval = 1 * time.Hour
+	librarySourcePrefix = "library:"
+	// libraryMissTTL is how long a title without a TMDB match is not looked
+	// up again, TMDB may have added it since.
+	libraryMissTTL = 24 * time.Hour
+)
+
+var (
+	libraryMu      sync.RWMutex
+	libraryEntries = map[string]libraryEntry{}
+
+	libraryScanMu sync.Mutex
+	// libraryMatches caches TMDB lookups by parsed title so that rescans do
+	// not hit TMDB again for files that were already looked up.
+	libraryMatches sync.Map
+)
+
+type libraryMatch struct {
+	id        int
+	checkedAt time.Time
+}
+
+type libraryEntry struct {
+	Id         string    `json:"id"`
+	Path       string    `json:"path"`
+	Type       string    `json:"type"`
+	TmdbId     int       `json:"tmdbId"`
+	Title      string    `json:"title"`
+	Year       string    `json:"year,omitempty"`
+	Season     int       `json:"season,omitempty"`
+	Episode    int       `json:"episode,omitempty"`
+	Size       int64     `json:"size"`
+	Resolution int       `json:"resolution"`
+	ModTime    time.Time `json:"modTime"`
+}
+
+func initLibrary() {
+	go func() {
+		scanLibrary()
+		ticker := time.NewTicker(libraryScanInterval)
+		for range ticker.C {
+			scanLibrary()
+		}
+	}()
+}
+
+func libraryDirs() []string {
+	return append([]string{cfg.LibraryDir}, cfg.MediaDirs...)
+}
+
+func scanLibrary() {
+	if !libraryScanMu.TryLock() {
+		return
+	}
+	defer libraryScanMu.Unlock()
+
+	start := time.Now()
+	ctx := context.Background()
+	entries := map[string]libraryEntry{}
+
+	for _, dir := range libraryDirs() {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if !os.IsNotExist(err) {
+					slog.Warn("Failed to scan library path", "path", path, "err", err)
+				}
+				return nil
+			}
+			if d.IsDir() || !videoExts[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+
+			entry, ok := parseLibraryFile(ctx, path)
+			if !ok {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				entry.Size = info.Size()
+				entry.ModTime = info.ModTime()
+			}
+			entries[entry.Id] = entry
+			return nil
+		})
+	}
+
+	libraryMu.Lock()
+	libraryEntries = entries
+	libraryMu.Unlock()
+
+	slog.Info("Library indexed", "files", len(entries), "duration", time.Since(start))
+}
+
+func parseLibraryFile(ctx context.Context, path string) (libraryEntry, bool) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	sum := sha1.Sum([]byte(path))
+
+	entry := libraryEntry{
+		Id:         hex.EncodeToString(sum[:8]),
+		Path:       path,
+		Resolution: parseResolution(name),
+	}
+
+	if groups := namedGroup(tvRegex, name); groups != nil {
+		entry.Type = "episode"
+		entry.Title = strings.Trim(groups["title"], " .-_")
+		entry.Year = groups["year"]
+		entry.Season, _ = strconv.Atoi(groups["season"])
+		entry.Episode, _ = strconv.Atoi(groups["episode"])
+	} else if groups := namedGroup(movieRegex, name); groups != nil {
+		entry.Type = "movie"
+		entry.Title = strings.Trim(groups["title"], " .-_")
+		entry.Year = groups["year"]
+	} else {
+		return entry, false
+	}
+
+	entry.TmdbId = matchLibraryTitle(ctx, entry.Type, entry.Title, entry.Year)
+	if entry.TmdbId == 0 {
+		slog.DebugContext(ctx, "No TMDB match for library file", "path", path)
+		return entry, false
+	}
+	return entry, true
+}
+
+// matchLibraryTitle looks a parsed title up on TMDB and returns the id of the
+// first result whose cleaned title matches, falling back to the top result.
+func matchLibraryTitle(ctx context.Context, mediaType, title, year string) int {
+	key := fmt.Sprintf("%s|%s|%s", mediaType, cleanTitle(title), year)
+	if m, ok := libraryMatches.Load(key); ok {
+		if m := m.(libraryMatch); m.id != 0 || time.Since(m.checkedAt) < libraryMissTTL {
+			return m.id
+		}
+	}
+
+	kind := "tv"
+	if mediaType == "movie" {
+		kind = "movie"
+	}
+	results, err := metadata.Search(ctx, kind, strings.NewReplacer(".", " ", "_", " ").Replace(title), year)
+	if err != nil {
+		slog.WarnContext(ctx, "TMDB lookup failed", "title", title, "err", err)
+		return 0
+	}
+
+	id := 0
+	for i, r := range results {
+		if i == 0 {
+			id = r.Id
+		}
+		if clean := cleanTitle(title); cleanTitle(r.Title+r.Name) == clean || cleanTitle(r.OriginalTitle+r.OriginalName) == clean {
+			id = r.Id
+			break
+		}
+	}
+
+	libraryMatches.Store(key, libraryMatch{id: id, checkedAt: time.Now()})
+	return id
+}
+
+func libraryEntryById(id string) (libraryEntry, bool) {
+	libraryMu.RLock()
+	defer libraryMu.RUnlock()
+	entry, ok := libraryEntries[id]
+	return entry, ok
+}
+
+func findLibraryEntries(mediaType string, tmdbId, season, episode int) []libraryEntry {
+	libraryMu.RLock()
+	defer libraryMu.RUnlock()
+
+	out := []libraryEntry{}
+	for _, e := range libraryEntries {
+		if mediaType != "" && e.Type != mediaType {
+			continue
+		}
+		if tmdbId != 0 && e.TmdbId != tmdbId {
+			continue
+		}
+		if season != 0 && e.Season != season {
+			continue
+		}
+		if episode != 0 && e.Episode != episode {
+			continue
+		}
+		out = append(out, e)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Path < out[j].Path
+	})
+	return out
+}
+
+// libraryResults presents local copies of a title in the same shape as
+// indexer results, so the frontend can offer them as sources.
+func libraryResults(mediaType string, tmdbId, season, episode int) []prowlarrResult {
+	var results []prowlarrResult
+	for _, e := range findLibraryEntries(mediaType, tmdbId, season, episode) {
+		results = append(results, prowlarrResult{
+			Title:       filepath.Base(e.Path),
+			Guid:        librarySourcePrefix + e.Id,
+			Link:        librarySourcePrefix + e.Id,
+			PubDate:     e.ModTime.Format(time.RFC3339),
+			Category:    "Library",
+			Size:        e.Size,
+			Resolution:  e.Resolution,
+			IndexerName: "Library",
+		})
+	}
+	return results
+}
+
+func handleLibrary(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	tmdbId, _ := strconv.Atoi(q.Get("tmdbId"))
+	season, _ := strconv.Atoi(q.Get("season"))
+	episode, _ := strconv.Atoi(q.Get("episode"))
+
+	writeJSON(w, findLibraryEntries(q.Get("type"), tmdbId, season, episode))
+}
+
+func handleLibraryScan(w http.ResponseWriter, r *http.Request) {
+	go scanLibrary()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func handleLibraryStream(w http.ResponseWriter, r *http.Request) {
+	entry, ok := libraryEntryById(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Library file not found", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		http.Error(w, "Failed to open library file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Failed to open library file", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Serving library file", "path", entry.Path)
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, entry.Id, info.ModTime().Unix()))
+	http.ServeContent(w, r, filepath.Base(entry.Path), info.ModTime(), f)
+}
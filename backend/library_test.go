@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// countingSearch counts TMDB searches and can fail them.
+type countingSearch struct {
+	fixtureProvider
+	searches int
+	err      error
+}
+
+func (p *countingSearch) Search(ctx context.Context, kind, query, year string) ([]tmdbSearchResult, error) {
+	p.searches++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.fixtureProvider.Search(ctx, kind, query, year)
+}
+
+func useLibraryMatches(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() { libraryMatches.Clear() })
+	libraryMatches.Clear()
+}
+
+func TestParseLibraryFile(t *testing.T) {
+	useMetadata(t, fixtureProvider{dir: "testdata/metadata"})
+	useLibraryMatches(t)
+
+	tests := []struct {
+		file string
+		want libraryEntry
+		ok   bool
+	}{
+		{"The.Matrix.1999.1080p.BluRay.x264.mkv", libraryEntry{Type: "movie", TmdbId: 603, Title: "The.Matrix", Year: "1999", Resolution: 1080}, true},
+		{"The Matrix Reloaded (2003).mp4", libraryEntry{Type: "movie", TmdbId: 604, Title: "The Matrix Reloaded", Year: "2003"}, true},
+		{"Game.of.Thrones.S01E02.720p.WEB.mkv", libraryEntry{Type: "episode", TmdbId: 1399, Title: "Game.of.Thrones", Season: 1, Episode: 2, Resolution: 720}, true},
+		{"Game of Thrones (2011) S02E10.mkv", libraryEntry{Type: "episode", TmdbId: 1399, Title: "Game of Thrones", Year: "2011", Season: 2, Episode: 10}, true},
+		{"Unknown.Film.2020.2160p.mkv", libraryEntry{}, false},
+		{"home video.mkv", libraryEntry{}, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join("/media", tt.file)
+		got, ok := parseLibraryFile(context.Background(), path)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.file, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		tt.want.Id, tt.want.Path = got.Id, path
+		if got != tt.want {
+			t.Errorf("%s: entry = %+v, want %+v", tt.file, got, tt.want)
+		}
+		if again, _ := parseLibraryFile(context.Background(), path); again.Id != got.Id || len(got.Id) != 16 {
+			t.Errorf("%s: id %q is not stable (%q)", tt.file, got.Id, again.Id)
+		}
+	}
+}
+
+func TestMatchLibraryTitleCachesLookups(t *testing.T) {
+	p := &countingSearch{fixtureProvider: fixtureProvider{dir: "testdata/metadata"}}
+	useMetadata(t, p)
+	useLibraryMatches(t)
+	ctx := context.Background()
+
+	for range 2 {
+		if id := matchLibraryTitle(ctx, "movie", "The.Matrix", "1999"); id != 603 {
+			t.Errorf("match = %d, want 603", id)
+		}
+		if id := matchLibraryTitle(ctx, "movie", "Unknown.Film", "2020"); id != 0 {
+			t.Errorf("miss = %d, want 0", id)
+		}
+	}
+	if p.searches != 2 {
+		t.Errorf("searches = %d, want one per title", p.searches)
+	}
+
+	// Misses are looked up again once they expire.
+	key := "movie|" + cleanTitle("Unknown.Film") + "|2020"
+	libraryMatches.Store(key, libraryMatch{checkedAt: time.Now().Add(-libraryMissTTL)})
+	matchLibraryTitle(ctx, "movie", "Unknown.Film", "2020")
+	if p.searches != 3 {
+		t.Errorf("searches after the miss expired = %d, want 3", p.searches)
+	}
+
+	// Failed lookups are not cached at all.
+	p.err = errors.New("TMDB is down")
+	for range 2 {
+		if id := matchLibraryTitle(ctx, "tv", "Game.of.Thrones", ""); id != 0 {
+			t.Errorf("failed lookup = %d", id)
+		}
+	}
+	if p.searches != 5 {
+		t.Errorf("searches with errors = %d, want 5", p.searches)
+	}
+}
+
+func TestLibraryResults(t *testing.T) {
+	old := libraryEntries
+	t.Cleanup(func() { libraryEntries = old })
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	libraryEntries = map[string]libraryEntry{
+		"a": {Id: "a", Path: "/media/b/Show.S01E01.1080p.mkv", Type: "episode", TmdbId: 1399, Season: 1, Episode: 1, Size: 100, Resolution: 1080, ModTime: modTime},
+		"b": {Id: "b", Path: "/media/a/Show.S01E01.720p.mkv", Type: "episode", TmdbId: 1399, Season: 1, Episode: 1, Size: 50, Resolution: 720, ModTime: modTime},
+		"c": {Id: "c", Path: "/media/Show.S01E02.mkv", Type: "episode", TmdbId: 1399, Season: 1, Episode: 2},
+		"d": {Id: "d", Path: "/media/Movie.mkv", Type: "movie", TmdbId: 1399},
+	}
+
+	tests := []struct {
+		mediaType               string
+		tmdbId, season, episode int
+		want                    []string
+	}{
+		{"episode", 1399, 1, 1, []string{"b", "a"}},
+		{"episode", 1399, 1, 0, []string{"c", "b", "a"}},
+		{"movie", 1399, 0, 0, []string{"d"}},
+		{"episode", 1399, 2, 0, nil},
+		{"episode", 603, 1, 1, nil},
+	}
+	for _, tt := range tests {
+		results := libraryResults(tt.mediaType, tt.tmdbId, tt.season, tt.episode)
+		var got []string
+		for _, r := range results {
+			got = append(got, r.Guid[len(librarySourcePrefix):])
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("libraryResults(%s, %d, %d, %d) = %v, want %v", tt.mediaType, tt.tmdbId, tt.season, tt.episode, got, tt.want)
+		}
+	}
+
+	r := libraryResults("episode", 1399, 1, 1)[1]
+	want := prowlarrResult{
+		Title:       "Show.S01E01.1080p.mkv",
+		Guid:        "library:a",
+		Link:        "library:a",
+		PubDate:     "2024-05-01T12:00:00Z",
+		Category:    "Library",
+		Size:        100,
+		Resolution:  1080,
+		IndexerName: "Library",
+	}
+	if r != want {
+		t.Errorf("result = %+v, want %+v", r, want)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// fallbackLanguage fills in titles and overviews that have no translation in
+// the requested language.
+const fallbackLanguage = "en-US"
+
+var (
+	languageRegex = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+	regionRegex   = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// locale is the TMDB language (ISO 639-1 with optional region, e.g. "de-DE")
+// and region (ISO 3166-1, e.g. "DE") metadata is requested in.
+type locale struct {
+	Language string `json:"language"`
+	Region   string `json:"region"`
+}
+
+func (l locale) validate() error {
+	if l.Language != "" && !languageRegex.MatchString(l.Language) {
+		return errors.New("language must look like \"en\" or \"en-US\"")
+	}
+	if l.Region != "" && !regionRegex.MatchString(l.Region) {
+		return errors.New("region must be a two letter country code like \"US\"")
+	}
+	return nil
+}
+
+func (l locale) english() bool {
+	return l.Language == "" || l.Language == "en" || strings.HasPrefix(l.Language, "en-")
+}
+
+// path adds the language and region parameters to a TMDB path.
+func (l locale) path(p string) string {
+	var params []string
+	if l.Language != "" {
+		params = append(params, "language="+l.Language)
+	}
+	if l.Region != "" {
+		params = append(params, "region="+l.Region)
+	}
+	if len(params) == 0 {
+		return p
+	}
+	sep := "?"
+	if strings.Contains(p, "?") {
+		sep = "&"
+	}
+	return p + sep + strings.Join(params, "&")
+}
+
+type localeCtxKey struct{}
+
+// englishContext fetches metadata in English regardless of the user's or the
+// server's language. Releases and library folders are named after the English
+// or original title, so indexer searches and library paths must not depend on
+// who asks.
+func englishContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, localeCtxKey{}, locale{Language: fallbackLanguage, Region: settings().Region})
+}
+
+// requestLocale returns the locale of the signed in user, falling back to the
+// server-wide setting for anything the user has not set and for background
+// jobs.
+func requestLocale(ctx context.Context) locale {
+	if l, ok := ctx.Value(localeCtxKey{}).(locale); ok {
+		return l
+	}
+	s := settings()
+	l := locale{Language: s.Language, Region: s.Region}
+	if u, ok := ctx.Value(userCtxKey{}).(user); ok {
+		if u.Language != "" {
+			l.Language = u.Language
+		}
+		if u.Region != "" {
+			l.Region = u.Region
+		}
+	}
+	return l
+}
+
+// tmdbGetLocalized fetches path in the request's locale. fill is called to
+// replace empty fields with their English value, en fetches the English
+// response on first use.
+func tmdbGetLocalized[T any](ctx context.Context, path string, fill func(v *T, en func() T)) (T, error) {
+	l := requestLocale(ctx)
+	var v T
+	if err := tmdbGetJSON(ctx, l.path(path), &v); err != nil {
+		return v, err
+	}
+	if l.english() {
+		return v, nil
+	}
+
+	en := sync.OnceValue(func() T {
+		var e T
+		if err := tmdbGetJSON(ctx, locale{Language: fallbackLanguage, Region: l.Region}.path(path), &e); err != nil {
+			slog.DebugContext(ctx, "Failed to fetch English fallback", "path", path, "err", err)
+		}
+		return e
+	})
+	fill(&v, en)
+	return v, nil
+}
+
+// fallback sets *field to the value from en when it is empty.
+func fallback(field *string, en func() string) {
+	if *field == "" {
+		*field = en()
+	}
+}
+
+// handleUpdateLocale sets the signed in user's metadata language and region.
+// Empty values use the server default.
+func handleUpdateLocale(w http.ResponseWriter, r *http.Request) {
+	u, _ := currentUser(r)
+
+	var l locale
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := l.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := store.SetUserLocale(u.Id, l.Language, l.Region); err != nil {
+		http.Error(w, "Failed to save locale", http.StatusInternalServerError)
+		return
+	}
+	u.Language, u.Region = l.Language, l.Region
+	writeJSON(w, u)
+}
+
+func (s *Store) SetUserLocale(userId int64, language, region string) error {
+	_, err := s.db.Exec("UPDATE users SET language = ?, region = ? WHERE id = ?", language, region, userId)
+	return err
+}
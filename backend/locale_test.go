@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestTmdbDetailsFallBackToEnglish(t *testing.T) {
+	useTmdbServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("language") {
+		case "de-DE":
+			w.Write([]byte(`{"id": 603, "title": "Matrix", "original_title": "The Matrix", "overview": "", "tagline": "Willkommen"}`))
+		case "en-US":
+			w.Write([]byte(`{"id": 603, "title": "The Matrix", "overview": "A hacker learns the truth.", "tagline": "Welcome"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	useSettings(t, liveSettings{Language: "en-US"})
+
+	ctx := context.WithValue(context.Background(), userCtxKey{}, user{Language: "de-DE"})
+	m, err := tmdbMovieDetails(ctx, 603)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Matrix" || m.Tagline != "Willkommen" {
+		t.Errorf("translated fields replaced: %+v", m)
+	}
+	if m.Overview != "A hacker learns the truth." {
+		t.Errorf("overview = %q, want English fallback", m.Overview)
+	}
+
+	m, err = tmdbMovieDetails(context.Background(), 603)
+	if err != nil || m.Title != "The Matrix" {
+		t.Errorf("server default: %+v, %v", m, err)
+	}
+
+	// Indexer searches and library paths ignore the user's language.
+	m, err = tmdbMovieDetails(englishContext(ctx), 603)
+	if err != nil || m.Title != "The Matrix" {
+		t.Errorf("english context: %+v, %v", m, err)
+	}
+}
+
+func TestUserLocaleIsPersisted(t *testing.T) {
+	s := openTestStore(t)
+	u, err := s.CreateUser("alice", "hash", roleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUserLocale(u.Id, "fr-FR", "CA"); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := s.GetUser(u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Language != "fr-FR" || got.Region != "CA" {
+		t.Errorf("locale = %q/%q", got.Language, got.Region)
+	}
+}
+
+func TestLocalePath(t *testing.T) {
+	tests := []struct {
+		l    locale
+		path string
+		want string
+	}{
+		{locale{}, "/movie/1", "/movie/1"},
+		{locale{Language: "de-DE"}, "/movie/1", "/movie/1?language=de-DE"},
+		{locale{Language: "pt-BR", Region: "BR"}, "/search/movie?query=x", "/search/movie?query=x&language=pt-BR&region=BR"},
+	}
+	for _, tt := range tests {
+		if got := tt.l.path(tt.path); got != tt.want {
+			t.Errorf("%+v.path(%q) = %q, want %q", tt.l, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestLog(t *testing.T) {
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(requestIdHandler{slog.NewTextHandler(&buf, nil)}))
+	t.Cleanup(func() { slog.SetDefault(old) })
+
+	h := withRequestLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "inside handler")
+		w.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest("GET", "/api/stream/abc/0", nil)
+	req.Header.Set("X-Request-Id", "proxy-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Request-Id"); got != "proxy-123" {
+		t.Errorf("X-Request-Id = %q", got)
+	}
+	out := buf.String()
+	if strings.Count(out, "request_id=proxy-123") != 2 {
+		t.Errorf("request ID missing from log lines:\n%s", out)
+	}
+	if !strings.Contains(out, "bytes=5") || !strings.Contains(out, "status=200") {
+		t.Errorf("access log missing status or bytes:\n%s", out)
+	}
+
+	req = httptest.NewRequest("GET", "/api/me", nil)
+	req.Header.Set("X-Request-Id", "bad id with spaces")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-Id"); got == "" || strings.Contains(got, " ") {
+		t.Errorf("malformed request ID was not replaced: %q", got)
+	}
+}
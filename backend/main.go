@@ -4,7 +4,7 @@ import (
 	"context"
 	"embed"
 	"io/fs"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,6 +12,7 @@ import (
 	"syscall"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 //go:embed all:public
@@ -22,40 +23,128 @@ var cfg Config
 func main() {
 	godotenv.Load("../.env", ".env")
 
-	cfg = loadConfig()
+	var err error
+	cfg, err = loadConfig()
+	if err != nil {
+		logConfigErrors(err)
+		os.Exit(1)
+	}
+	applySettings(cfg.Live)
 
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	initLogging()
+
+	store, err = openStore(cfg.DataDir)
+	if err != nil {
+		fatal("Failed to open store", "err", err)
+	}
+	defer store.Close()
+
+	if _, err := setBaseSettings(cfg.Live); err != nil {
+		fatal("Failed to load settings", "err", err)
+	}
 
+	metadata = newMetadataProvider(cfg)
+	if cfg.MetadataFixtures != "" {
+		slog.Info("Serving metadata from fixtures", "dir", cfg.MetadataFixtures)
+	}
+	initTmdbCache()
+	initImageCache()
+	initAuth()
 	initTorrentClient()
+	initLibrary()
+	initPrecache()
+	initMonitor()
+	go logDependencyStatus()
 
 	buildFS, err := fs.Sub(staticFiles, "public")
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to load static files", "err", err)
 	}
 	fileServer := http.FileServer(http.FS(buildFS))
 
 	mux := http.NewServeMux()
 
+	mux.HandleFunc("GET /api/setup", handleSetupStatus)
+	mux.HandleFunc("POST /api/setup", handleSetup)
+	mux.HandleFunc("POST /api/login", handleLogin)
+	mux.HandleFunc("POST /api/logout", handleLogout)
+	mux.HandleFunc("GET /api/me", handleMe)
+	mux.HandleFunc("PUT /api/me/locale", handleUpdateLocale)
+	mux.HandleFunc("GET /api/me/notifications", handleGetNotifyTargets)
+	mux.HandleFunc("PUT /api/me/notifications", handleUpdateNotifyTargets)
+	mux.HandleFunc("GET /api/users", requireAdmin(handleListUsers))
+	mux.HandleFunc("POST /api/users", requireAdmin(handleCreateUser))
+	mux.HandleFunc("DELETE /api/users/{id}", requireAdmin(handleDeleteUser))
+	mux.Handle("GET /metrics", requireMetricsAccess(promhttp.Handler()))
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.HandleFunc("GET /api/status", handleStatus)
+	mux.HandleFunc("GET /api/webhooks/deliveries", requireAdmin(handleWebhookDeliveries))
+	mux.HandleFunc("POST /api/admin/reload", requireAdmin(handleReloadConfig))
+	mux.HandleFunc("GET /api/admin/settings", requireAdmin(handleGetSettings))
+	mux.HandleFunc("PATCH /api/admin/settings", requireAdmin(handleUpdateSettings))
+	mux.HandleFunc("DELETE /api/admin/settings", requireAdmin(handleResetSettings))
+
 	mux.HandleFunc("POST /api/torrent", handleAddTorrent)
+	mux.HandleFunc("DELETE /api/torrent/{hash}", requireAdmin(handleDeleteTorrent))
 	mux.HandleFunc("GET /api/stream/{hash}/{fileIdx}", handleStream)
+	mux.HandleFunc("GET /api/download/{hash}/{fileIdx}", handleDownload)
+	mux.HandleFunc("POST /api/keep", requireAdmin(handleKeep))
+	mux.HandleFunc("GET /api/keep", handleKeepStatus)
 	mux.HandleFunc("GET /api/search", handleSearch)
+	mux.HandleFunc("GET /api/image/{size}/{path}", handleImage)
 	mux.HandleFunc("GET /api/movie", handleMovie)
 	mux.HandleFunc("GET /api/show", handleShow)
 	mux.HandleFunc("GET /api/season", handleSeason)
 	mux.HandleFunc("GET /api/episode", handleEpisode)
+	mux.HandleFunc("GET /api/person", handlePerson)
+	mux.HandleFunc("GET /api/collection", handleCollection)
+	mux.HandleFunc("GET /api/discover/trending", handleTrending)
+	mux.HandleFunc("GET /api/discover/popular", handlePopular)
+	mux.HandleFunc("GET /api/discover/top-rated", handleTopRated)
+	mux.HandleFunc("GET /api/discover/genres", handleGenres)
+	mux.HandleFunc("GET /api/discover/genres/{id}", handleGenre)
+	mux.HandleFunc("GET /api/discover/recommendations", handleRecommendations)
+	mux.HandleFunc("GET /api/discover/because-you-watched", handleBecauseYouWatched)
 	mux.HandleFunc("GET /api/indexer", handleIndexer)
+	mux.HandleFunc("POST /api/precheck", handlePrecheck)
+	mux.HandleFunc("GET /api/progress", handleGetProgress)
+	mux.HandleFunc("PUT /api/progress", handlePutProgress)
+	mux.HandleFunc("GET /api/continue-watching", handleContinueWatching)
+	mux.HandleFunc("GET /api/up-next", handleUpNext)
+	mux.HandleFunc("GET /api/episode-availability", handleEpisodeAvailability)
+	mux.HandleFunc("GET /api/watchlist", handleList(listWatchlist))
+	mux.HandleFunc("POST /api/watchlist", handleAddToList(listWatchlist))
+	mux.HandleFunc("DELETE /api/watchlist/{type}/{tmdbId}", handleRemoveFromList(listWatchlist))
+	mux.HandleFunc("GET /api/favorites", handleList(listFavorites))
+	mux.HandleFunc("POST /api/favorites", handleAddToList(listFavorites))
+	mux.HandleFunc("DELETE /api/favorites/{type}/{tmdbId}", handleRemoveFromList(listFavorites))
+	mux.HandleFunc("GET /api/library", handleLibrary)
+	mux.HandleFunc("POST /api/library/scan", requireAdmin(handleLibraryScan))
+	mux.HandleFunc("GET /api/library/stream/{id}", handleLibraryStream)
 
 	mux.Handle("/", spaHandler(buildFS, fileServer))
 
 	server := &http.Server{
 		Addr:    ":" + cfg.Port,
-		Handler: withSecurityHeaders(mux),
+		Handler: withRequestLog(withSecurityHeaders(withAuth(mux))),
 	}
 
 	go func() {
-		log.Printf("Server listening on :%s", cfg.Port)
+		slog.Info("Server listening", "port", cfg.Port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+			fatal("Server error", "err", err)
+		}
+	}()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("Reloading configuration")
+			if _, _, err := reloadAndLog(); err != nil {
+				logConfigErrors(err)
+			}
 		}
 	}()
 
@@ -63,7 +152,7 @@ func main() {
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	<-stop
 
-	log.Println("Shutting down...")
+	slog.Info("Shutting down")
 	server.Shutdown(context.Background())
 }
 
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// The types below are the JSON schema of the metadata endpoints. They are
+// owned by Kiroshi, the frontend never sees TMDB's response shape.
+
+const maxCastMembers = 20
+
+type mediaSummary struct {
+	Id            int     `json:"id"`
+	Type          string  `json:"type"`
+	Title         string  `json:"title"`
+	OriginalTitle string  `json:"originalTitle"`
+	Overview      string  `json:"overview"`
+	ReleaseDate   string  `json:"releaseDate"`
+	PosterPath    string  `json:"posterPath"`
+	BackdropPath  string  `json:"backdropPath"`
+	Rating        float64 `json:"rating"`
+}
+
+type castMember struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Character   string `json:"character"`
+	ProfilePath string `json:"profilePath"`
+}
+
+type video struct {
+	Name string `json:"name"`
+	Site string `json:"site"`
+	Key  string `json:"key"`
+	Type string `json:"type"`
+}
+
+type searchResponse struct {
+	Movies []mediaSummary `json:"movies"`
+	Shows  []mediaSummary `json:"shows"`
+}
+
+type collectionSummary struct {
+	Id         int    `json:"id"`
+	Name       string `json:"name"`
+	PosterPath string `json:"posterPath"`
+}
+
+type movieDetails struct {
+	mediaSummary
+	ImdbId     string             `json:"imdbId"`
+	Tagline    string             `json:"tagline"`
+	Runtime    int                `json:"runtime"`
+	Genres     []string           `json:"genres"`
+	Collection *collectionSummary `json:"collection"`
+	Cast       []castMember       `json:"cast"`
+	Videos     []video            `json:"videos"`
+}
+
+type seasonSummary struct {
+	Season       int    `json:"season"`
+	Name         string `json:"name"`
+	EpisodeCount int    `json:"episodeCount"`
+	AirDate      string `json:"airDate"`
+	PosterPath   string `json:"posterPath"`
+}
+
+type showDetails struct {
+	mediaSummary
+	ImdbId      string          `json:"imdbId"`
+	LastAirDate string          `json:"lastAirDate"`
+	Status      string          `json:"status"`
+	Genres      []string        `json:"genres"`
+	Seasons     []seasonSummary `json:"seasons"`
+	NextEpisode *episodeSummary `json:"nextEpisode"`
+	Cast        []castMember    `json:"cast"`
+	Videos      []video         `json:"videos"`
+}
+
+type episodeSummary struct {
+	Season    int     `json:"season"`
+	Episode   int     `json:"episode"`
+	Name      string  `json:"name"`
+	Overview  string  `json:"overview"`
+	AirDate   string  `json:"airDate"`
+	StillPath string  `json:"stillPath"`
+	Runtime   int     `json:"runtime"`
+	Rating    float64 `json:"rating"`
+}
+
+type seasonDetails struct {
+	ShowId     int              `json:"showId"`
+	Season     int              `json:"season"`
+	Name       string           `json:"name"`
+	Overview   string           `json:"overview"`
+	AirDate    string           `json:"airDate"`
+	PosterPath string           `json:"posterPath"`
+	Episodes   []episodeSummary `json:"episodes"`
+}
+
+type episodeDetails struct {
+	episodeSummary
+	ShowId     int          `json:"showId"`
+	ImdbId     string       `json:"imdbId"`
+	Cast       []castMember `json:"cast"`
+	GuestStars []castMember `json:"guestStars"`
+}
+
+func newMovieSummary(r tmdbSearchResult) mediaSummary {
+	return mediaSummary{
+		Id:            r.Id,
+		Type:          "movie",
+		Title:         r.Title,
+		OriginalTitle: r.OriginalTitle,
+		Overview:      r.Overview,
+		ReleaseDate:   r.ReleaseDate,
+		PosterPath:    r.PosterPath,
+		BackdropPath:  r.BackdropPath,
+		Rating:        r.VoteAverage,
+	}
+}
+
+func newShowSummary(r tmdbSearchResult) mediaSummary {
+	return mediaSummary{
+		Id:            r.Id,
+		Type:          "show",
+		Title:         r.Name,
+		OriginalTitle: r.OriginalName,
+		Overview:      r.Overview,
+		ReleaseDate:   r.FirstAirDate,
+		PosterPath:    r.PosterPath,
+		BackdropPath:  r.BackdropPath,
+		Rating:        r.VoteAverage,
+	}
+}
+
+func newMovieDetails(m tmdbMovie) movieDetails {
+	d := movieDetails{
+		mediaSummary: mediaSummary{
+			Id:            m.Id,
+			Type:          "movie",
+			Title:         m.Title,
+			OriginalTitle: m.OriginalTitle,
+			Overview:      m.Overview,
+			ReleaseDate:   m.ReleaseDate,
+			PosterPath:    m.PosterPath,
+			BackdropPath:  m.BackdropPath,
+			Rating:        m.VoteAverage,
+		},
+		ImdbId:  m.ImdbId,
+		Tagline: m.Tagline,
+		Runtime: m.Runtime,
+		Genres:  genreNames(m.Genres),
+		Cast:    newCast(m.Credits.Cast),
+		Videos:  newVideos(m.Videos.Results),
+	}
+	if c := m.BelongsToCollection; c != nil {
+		d.Collection = &collectionSummary{Id: c.Id, Name: c.Name, PosterPath: c.PosterPath}
+	}
+	return d
+}
+
+func newShowDetails(s tmdbShow) showDetails {
+	d := showDetails{
+		mediaSummary: mediaSummary{
+			Id:            s.Id,
+			Type:          "show",
+			Title:         s.Name,
+			OriginalTitle: s.OriginalName,
+			Overview:      s.Overview,
+			ReleaseDate:   s.FirstAirDate,
+			PosterPath:    s.PosterPath,
+			BackdropPath:  s.BackdropPath,
+			Rating:        s.VoteAverage,
+		},
+		ImdbId:      s.ExternalIds.ImdbId,
+		LastAirDate: s.LastAirDate,
+		Status:      s.Status,
+		Genres:      genreNames(s.Genres),
+		Seasons:     []seasonSummary{},
+		Cast:        newCast(s.Credits.Cast),
+		Videos:      newVideos(s.Videos.Results),
+	}
+	for _, season := range s.Seasons {
+		d.Seasons = append(d.Seasons, seasonSummary{
+			Season:       season.SeasonNumber,
+			Name:         season.Name,
+			EpisodeCount: season.EpisodeCount,
+			AirDate:      season.AirDate,
+			PosterPath:   season.PosterPath,
+		})
+	}
+	if s.NextEpisodeToAir != nil {
+		next := newEpisodeSummary(*s.NextEpisodeToAir)
+		d.NextEpisode = &next
+	}
+	return d
+}
+
+func newSeasonDetails(showId int, s tmdbSeason) seasonDetails {
+	d := seasonDetails{
+		ShowId:     showId,
+		Season:     s.SeasonNumber,
+		Name:       s.Name,
+		Overview:   s.Overview,
+		AirDate:    s.AirDate,
+		PosterPath: s.PosterPath,
+		Episodes:   []episodeSummary{},
+	}
+	for _, ep := range s.Episodes {
+		d.Episodes = append(d.Episodes, newEpisodeSummary(ep))
+	}
+	return d
+}
+
+func newEpisodeSummary(ep tmdbEpisodeSummary) episodeSummary {
+	return episodeSummary{
+		Season:    ep.SeasonNumber,
+		Episode:   ep.EpisodeNumber,
+		Name:      ep.Name,
+		Overview:  ep.Overview,
+		AirDate:   ep.AirDate,
+		StillPath: ep.StillPath,
+		Runtime:   ep.Runtime,
+		Rating:    ep.VoteAverage,
+	}
+}
+
+func newEpisodeDetails(showId int, ep tmdbEpisode) episodeDetails {
+	return episodeDetails{
+		episodeSummary: newEpisodeSummary(ep.tmdbEpisodeSummary),
+		ShowId:         showId,
+		ImdbId:         ep.ExternalIds.ImdbId,
+		Cast:           newCast(ep.Credits.Cast),
+		GuestStars:     newCast(ep.Credits.GuestStars),
+	}
+}
+
+func genreNames(genres []tmdbGenre) []string {
+	names := []string{}
+	for _, g := range genres {
+		names = append(names, g.Name)
+	}
+	return names
+}
+
+func newCast(cast []tmdbCastMember) []castMember {
+	out := []castMember{}
+	for _, c := range cast[:min(len(cast), maxCastMembers)] {
+		out = append(out, castMember{Id: c.Id, Name: c.Name, Character: c.Character, ProfilePath: c.ProfilePath})
+	}
+	return out
+}
+
+func newVideos(videos []tmdbVideo) []video {
+	out := []video{}
+	for _, v := range videos {
+		out = append(out, video{Name: v.Name, Site: v.Site, Key: v.Key, Type: v.Type})
+	}
+	return out
+}
+
+// queryInts parses the named query parameters as non-negative integers, writing
+// a 400 and returning false if any is missing or invalid.
+func queryInts(w http.ResponseWriter, r *http.Request, names ...string) ([]int, bool) {
+	values := make([]int, len(names))
+	for i, name := range names {
+		v, err := strconv.Atoi(r.URL.Query().Get(name))
+		if err != nil || v < 0 {
+			http.Error(w, "Missing or invalid parameter: "+name, http.StatusBadRequest)
+			return nil, false
+		}
+		values[i] = v
+	}
+	return values, true
+}
+
+func writeMetadataError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errMetadataNotFound) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	slog.ErrorContext(r.Context(), "Metadata request failed", "provider", metadata.Name(), "err", err)
+	http.Error(w, "Metadata request failed", http.StatusBadGateway)
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	resp := searchResponse{Movies: []mediaSummary{}, Shows: []mediaSummary{}}
+	if q == "" {
+		writeJSON(w, resp)
+		return
+	}
+
+	search := func(ctx context.Context, kind string, convert func(tmdbSearchResult) mediaSummary, out *[]mediaSummary) error {
+		results, err := metadata.Search(ctx, kind, q, "")
+		for _, res := range results {
+			*out = append(*out, convert(res))
+		}
+		return err
+	}
+
+	movieErr := make(chan error, 1)
+	go func() { movieErr <- search(r.Context(), "movie", newMovieSummary, &resp.Movies) }()
+	showErr := search(r.Context(), "tv", newShowSummary, &resp.Shows)
+
+	if err := <-movieErr; err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+	if showErr != nil {
+		writeMetadataError(w, r, showErr)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+func handleMovie(w http.ResponseWriter, r *http.Request) {
+	ids, ok := queryInts(w, r, "id")
+	if !ok {
+		return
+	}
+	m, err := metadata.Movie(r.Context(), ids[0])
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+	writeJSON(w, newMovieDetails(m))
+}
+
+func handleShow(w http.ResponseWriter, r *http.Request) {
+	ids, ok := queryInts(w, r, "id")
+	if !ok {
+		return
+	}
+	s, err := metadata.Show(r.Context(), ids[0])
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+	writeJSON(w, newShowDetails(s))
+}
+
+func handleSeason(w http.ResponseWriter, r *http.Request) {
+	ids, ok := queryInts(w, r, "id", "season")
+	if !ok {
+		return
+	}
+	s, err := metadata.Season(r.Context(), ids[0], ids[1])
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+	writeJSON(w, newSeasonDetails(ids[0], s))
+}
+
+func handleEpisode(w http.ResponseWriter, r *http.Request) {
+	ids, ok := queryInts(w, r, "id", "season", "episode")
+	if !ok {
+		return
+	}
+	ep, err := metadata.Episode(r.Context(), ids[0], ids[1], ids[2])
+	if err != nil {
+		writeMetadataError(w, r, err)
+		return
+	}
+	writeJSON(w, newEpisodeDetails(ids[0], ep))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShowHandlerReturnsKiroshiSchema(t *testing.T) {
+	useTmdbServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tv/1399":
+			w.Write([]byte(`{
+				"id": 1399, "name": "Game of Thrones", "original_name": "Game of Thrones",
+				"first_air_date": "2011-04-17", "poster_path": "/p.jpg", "vote_average": 8.4,
+				"genres": [{"id": 18, "name": "Drama"}],
+				"seasons": [{"season_number": 1, "name": "Season 1", "episode_count": 10}],
+				"external_ids": {"imdb_id": "tt0944947"},
+				"credits": {"cast": [{"id": 1, "name": "Emilia Clarke", "character": "Daenerys", "profile_path": "/e.jpg"}]}
+			}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	handleShow(rec, httptest.NewRequest(http.MethodGet, "/api/show?id=1399", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var got map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{
+		"id":          1399.0,
+		"type":        "show",
+		"title":       "Game of Thrones",
+		"releaseDate": "2011-04-17",
+		"imdbId":      "tt0944947",
+		"posterPath":  "/p.jpg",
+	} {
+		if got[key] != want {
+			t.Errorf("%s = %v, want %v", key, got[key], want)
+		}
+	}
+	if _, ok := got["first_air_date"]; ok {
+		t.Error("response contains TMDB fields")
+	}
+	if seasons := got["seasons"].([]any); len(seasons) != 1 || seasons[0].(map[string]any)["season"] != 1.0 {
+		t.Errorf("seasons = %v", seasons)
+	}
+
+	rec = httptest.NewRecorder()
+	handleShow(rec, httptest.NewRequest(http.MethodGet, "/api/show?id=1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing show status = %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handleSeason(rec, httptest.NewRequest(http.MethodGet, "/api/season?id=1399&season=1%2F..%2F..%2Fconfiguration", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid season status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// fixturePageSize is the page size of lists the fixture provider builds
+// itself, the same as TMDB's.
+const fixturePageSize = 20
+
+var errMetadataNotFound = errors.New("metadata not found")
+
+// MetadataProvider supplies movie and show metadata. Results use TMDB's
+// schema and ids, other providers map their responses onto it. kind is
+// "movie" or "tv".
+type MetadataProvider interface {
+	Name() string
+	Check(ctx context.Context) error
+
+	// Search finds movies or shows by title. year is optional.
+	Search(ctx context.Context, kind, query, year string) ([]tmdbSearchResult, error)
+	Movie(ctx context.Context, id int) (tmdbMovie, error)
+	Show(ctx context.Context, id int) (tmdbShow, error)
+	Season(ctx context.Context, showId, season int) (tmdbSeason, error)
+	Episode(ctx context.Context, showId, season, episode int) (tmdbEpisode, error)
+	// Person returns a person with their combined movie and show credits.
+	Person(ctx context.Context, id int) (tmdbPerson, error)
+	Collection(ctx context.Context, id int) (tmdbCollection, error)
+
+	// List returns a page of a list named by its TMDB path, like
+	// "trending/all/week", "tv/popular" or "movie/603/recommendations".
+	List(ctx context.Context, list string, page int) (tmdbPage, error)
+	Genres(ctx context.Context, kind string) ([]tmdbGenre, error)
+	// ByGenre returns the most popular titles of a genre.
+	ByGenre(ctx context.Context, kind string, genre, page int) (tmdbPage, error)
+}
+
+var metadata MetadataProvider = tmdbProvider{}
+
+func newMetadataProvider(c Config) MetadataProvider {
+	if c.MetadataFixtures != "" {
+		return fixtureProvider{dir: c.MetadataFixtures}
+	}
+	return tmdbProvider{}
+}
+
+// fixtureProvider serves metadata from JSON files laid out like TMDB's API,
+// for tests and offline demos:
+//
+//	movie/{id}.json                      a movie with credits and videos
+//	tv/{id}.json                         a show with credits, videos and external_ids
+//	tv/{id}/season/{n}.json              a season with its episodes
+//	tv/{id}/season/{n}/episode/{e}.json  optional, defaults to the season's entry
+//	person/{id}.json                     a person with combined_credits
+//	collection/{id}.json                 a collection with its parts
+//	{list}.json                          a list page, e.g. trending/all/week.json
+//
+// Search, genres and genre lists are built from the movie and show files.
+// Fixtures are served as is, whatever the requested language.
+type fixtureProvider struct {
+	dir string
+}
+
+// fixtureTitle is a movie or show file read as a search result.
+type fixtureTitle struct {
+	tmdbSearchResult
+	Genres []tmdbGenre `json:"genres"`
+}
+
+func (p fixtureProvider) read(path string, v any) error {
+	body, err := os.ReadFile(filepath.Join(p.dir, filepath.FromSlash(path)+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", errMetadataNotFound, path)
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("fixture %s: %w", path, err)
+	}
+	return nil
+}
+
+// titles reads every movie or show file of kind.
+func (p fixtureProvider) titles(kind string) ([]fixtureTitle, error) {
+	entries, err := os.ReadDir(filepath.Join(p.dir, kind))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []fixtureTitle
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok {
+			continue
+		}
+		var t fixtureTitle
+		if err := p.read(kind+"/"+name, &t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func (p fixtureProvider) Name() string {
+	return "fixtures"
+}
+
+func (p fixtureProvider) Check(ctx context.Context) error {
+	info, err := os.Stat(p.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", p.dir)
+	}
+	return nil
+}
+
+func (p fixtureProvider) Search(ctx context.Context, kind, query, year string) ([]tmdbSearchResult, error) {
+	titles, err := p.titles(kind)
+	query = strings.ToLower(query)
+	var out []tmdbSearchResult
+	for _, t := range titles {
+		names := strings.ToLower(strings.Join([]string{t.Title, t.Name, t.OriginalTitle, t.OriginalName}, "\n"))
+		if !strings.Contains(names, query) || !strings.HasPrefix(t.ReleaseDate+t.FirstAirDate, year) {
+			continue
+		}
+		out = append(out, t.tmdbSearchResult)
+	}
+	return out, err
+}
+
+func (p fixtureProvider) Movie(ctx context.Context, id int) (tmdbMovie, error) {
+	var m tmdbMovie
+	err := p.read(fmt.Sprintf("movie/%d", id), &m)
+	return m, err
+}
+
+func (p fixtureProvider) Show(ctx context.Context, id int) (tmdbShow, error) {
+	var s tmdbShow
+	err := p.read(fmt.Sprintf("tv/%d", id), &s)
+	return s, err
+}
+
+func (p fixtureProvider) Season(ctx context.Context, showId, season int) (tmdbSeason, error) {
+	var s tmdbSeason
+	err := p.read(fmt.Sprintf("tv/%d/season/%d", showId, season), &s)
+	return s, err
+}
+
+func (p fixtureProvider) Episode(ctx context.Context, showId, season, episode int) (tmdbEpisode, error) {
+	var ep tmdbEpisode
+	err := p.read(fmt.Sprintf("tv/%d/season/%d/episode/%d", showId, season, episode), &ep)
+	if !errors.Is(err, errMetadataNotFound) {
+		return ep, err
+	}
+
+	s, err := p.Season(ctx, showId, season)
+	if err != nil {
+		return ep, err
+	}
+	for _, e := range s.Episodes {
+		if e.EpisodeNumber == episode {
+			return tmdbEpisode{tmdbEpisodeSummary: e}, nil
+		}
+	}
+	return ep, fmt.Errorf("%w: episode %d of season %d of show %d", errMetadataNotFound, episode, season, showId)
+}
+
+func (p fixtureProvider) Person(ctx context.Context, id int) (tmdbPerson, error) {
+	var person tmdbPerson
+	err := p.read(fmt.Sprintf("person/%d", id), &person)
+	return person, err
+}
+
+func (p fixtureProvider) Collection(ctx context.Context, id int) (tmdbCollection, error) {
+	var c tmdbCollection
+	err := p.read(fmt.Sprintf("collection/%d", id), &c)
+	return c, err
+}
+
+// List serves {list}.json. Fixtures hold a single page, later pages and
+// missing lists are empty.
+func (p fixtureProvider) List(ctx context.Context, list string, page int) (tmdbPage, error) {
+	var out tmdbPage
+	if err := p.read(list, &out); err != nil && !errors.Is(err, errMetadataNotFound) {
+		return out, err
+	}
+	if page != 1 {
+		out.Results = nil
+	}
+	out.Page = page
+	return out, nil
+}
+
+func (p fixtureProvider) Genres(ctx context.Context, kind string) ([]tmdbGenre, error) {
+	titles, err := p.titles(kind)
+	var out []tmdbGenre
+	for _, t := range titles {
+		for _, g := range t.Genres {
+			if !slices.Contains(out, g) {
+				out = append(out, g)
+			}
+		}
+	}
+	slices.SortFunc(out, func(a, b tmdbGenre) int { return strings.Compare(a.Name, b.Name) })
+	return out, err
+}
+
+// ByGenre ranks the titles of a genre by rating, fixtures have no popularity.
+func (p fixtureProvider) ByGenre(ctx context.Context, kind string, genre, page int) (tmdbPage, error) {
+	titles, err := p.titles(kind)
+	if err != nil {
+		return tmdbPage{}, err
+	}
+	var matches []tmdbSearchResult
+	for _, t := range titles {
+		if slices.ContainsFunc(t.Genres, func(g tmdbGenre) bool { return g.Id == genre }) {
+			matches = append(matches, t.tmdbSearchResult)
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b tmdbSearchResult) int { return cmp.Compare(b.VoteAverage, a.VoteAverage) })
+
+	out := tmdbPage{
+		Page:         page,
+		TotalPages:   (len(matches) + fixturePageSize - 1) / fixturePageSize,
+		TotalResults: len(matches),
+	}
+	if start := (page - 1) * fixturePageSize; start < len(matches) {
+		out.Results = matches[start:min(start+fixturePageSize, len(matches))]
+	}
+	return out, nil
+}
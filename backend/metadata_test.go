@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func useMetadata(t *testing.T, p MetadataProvider) {
+	t.Helper()
+	old := metadata
+	t.Cleanup(func() { metadata = old })
+	metadata = p
+}
+
+func getJSON(t *testing.T, h http.HandlerFunc, target string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code == http.StatusOK && v != nil {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestFixtureProviderServesHandlers(t *testing.T) {
+	useMetadata(t, fixtureProvider{dir: "testdata/metadata"})
+
+	var search searchResponse
+	if code := getJSON(t, handleSearch, "/api/search?q=matrix", &search); code != http.StatusOK {
+		t.Fatalf("search status = %d", code)
+	}
+	if len(search.Movies) != 2 || len(search.Shows) != 0 || search.Movies[0].Title != "The Matrix" {
+		t.Errorf("search = %+v", search)
+	}
+
+	var movie movieDetails
+	if code := getJSON(t, handleMovie, "/api/movie?id=603", &movie); code != http.StatusOK {
+		t.Fatalf("movie status = %d", code)
+	}
+	if movie.ImdbId != "tt0133093" || len(movie.Cast) != 2 || len(movie.Videos) != 1 {
+		t.Errorf("movie = %+v", movie)
+	}
+	if code := getJSON(t, handleMovie, "/api/movie?id=1", nil); code != http.StatusNotFound {
+		t.Errorf("missing movie status = %d", code)
+	}
+
+	var ep episodeDetails
+	if code := getJSON(t, handleEpisode, "/api/episode?id=1399&season=1&episode=2", &ep); code != http.StatusOK {
+		t.Fatalf("episode status = %d", code)
+	}
+	if ep.Name != "The Kingsroad" || ep.Runtime != 56 {
+		t.Errorf("episode = %+v", ep)
+	}
+	if code := getJSON(t, handleEpisode, "/api/episode?id=1399&season=1&episode=3", nil); code != http.StatusNotFound {
+		t.Errorf("missing episode status = %d", code)
+	}
+
+	var trending mediaPage
+	if code := getJSON(t, handleTrending, "/api/discover/trending", &trending); code != http.StatusOK {
+		t.Fatalf("trending status = %d", code)
+	}
+	if len(trending.Results) != 2 || trending.Results[0].Type != "show" {
+		t.Errorf("trending = %+v", trending)
+	}
+	if code := getJSON(t, handlePopular, "/api/discover/popular", &trending); code != http.StatusOK || len(trending.Results) != 0 {
+		t.Errorf("missing list = %d %+v", code, trending)
+	}
+
+	var genres []genre
+	getJSON(t, handleGenres, "/api/discover/genres", &genres)
+	if len(genres) != 3 || genres[0].Name != "Action" {
+		t.Errorf("genres = %+v", genres)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/discover/genres/878", nil)
+	req.SetPathValue("id", "878")
+	rec := httptest.NewRecorder()
+	handleGenre(rec, req)
+	var scifi mediaPage
+	json.NewDecoder(rec.Body).Decode(&scifi)
+	if scifi.TotalResults != 2 || scifi.Results[0].Id != 603 {
+		t.Errorf("genre page = %+v", scifi)
+	}
+}
+
+func TestTmdbNotFoundMatchesProviderError(t *testing.T) {
+	useTmdbServer(t, http.NotFound)
+
+	rec := httptest.NewRecorder()
+	handleMovie(rec, httptest.NewRequest(http.MethodGet, "/api/movie?id=1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	torrentDrops = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kiroshi_torrent_drops_total",
+		Help: "Torrents removed from the client, by reason.",
+	}, []string{"reason"})
+
+	metadataDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kiroshi_torrent_metadata_seconds",
+		Help:    "Time handleAddTorrent waited for torrent metadata.",
+		Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60},
+	})
+
+	streamFirstByte = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kiroshi_stream_first_byte_seconds",
+		Help:    "Time from a stream request to the first byte of the response body.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+	})
+
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kiroshi_upstream_request_seconds",
+		Help:    "Latency of requests to Prowlarr and TMDB.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service"})
+
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kiroshi_upstream_errors_total",
+		Help: "Failed requests to Prowlarr and TMDB, including 4xx and 5xx responses.",
+	}, []string{"service"})
+
+	tmdbCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kiroshi_tmdb_cache_lookups_total",
+		Help: "TMDB cache lookups by result: hit, miss or stale (expired entry served because TMDB failed).",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(torrentDrops, metadataDuration, streamFirstByte, upstreamDuration, upstreamErrors, tmdbCacheLookups, &torrentCollector{})
+	for _, reason := range []string{dropInactive, dropRatio, dropStorage, dropDeleted, dropMetadataTimeout, dropNoVideo} {
+		torrentDrops.WithLabelValues(reason)
+	}
+	for _, service := range []string{"prowlarr", "tmdb", "tmdb_image"} {
+		upstreamDuration.WithLabelValues(service)
+		upstreamErrors.WithLabelValues(service)
+	}
+	for _, result := range []string{"hit", "miss", "stale"} {
+		tmdbCacheLookups.WithLabelValues(result)
+	}
+}
+
+var (
+	torrentsDesc    = prometheus.NewDesc("kiroshi_torrents_active", "Torrents currently in the client.", nil, nil)
+	peersDesc       = prometheus.NewDesc("kiroshi_peers", "Connected peers across all torrents.", nil, nil)
+	downloadedDesc  = prometheus.NewDesc("kiroshi_downloaded_bytes_total", "Bytes downloaded by the torrent client.", nil, nil)
+	uploadedDesc    = prometheus.NewDesc("kiroshi_uploaded_bytes_total", "Bytes uploaded by the torrent client.", nil, nil)
+	downloadRate    = prometheus.NewDesc("kiroshi_download_rate_bytes", "Download rate in bytes per second since the previous scrape.", nil, nil)
+	uploadRate      = prometheus.NewDesc("kiroshi_upload_rate_bytes", "Upload rate in bytes per second since the previous scrape.", nil, nil)
+	storageUsedDesc = prometheus.NewDesc("kiroshi_storage_used_bytes", "Bytes of torrent data on disk.", nil, nil)
+	storageMaxDesc  = prometheus.NewDesc("kiroshi_storage_limit_bytes", "Configured torrent storage limit.", nil, nil)
+)
+
+// torrentCollector reads client state on every scrape. Rates are computed
+// from the byte counters since the previous scrape.
+type torrentCollector struct {
+	mu         sync.Mutex
+	lastScrape time.Time
+	lastRead   int64
+	lastWrite  int64
+}
+
+func (c *torrentCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{torrentsDesc, peersDesc, downloadedDesc, uploadedDesc, downloadRate, uploadRate, storageUsedDesc, storageMaxDesc} {
+		ch <- d
+	}
+}
+
+func (c *torrentCollector) Collect(ch chan<- prometheus.Metric) {
+	if tClient == nil {
+		return
+	}
+	stats := tClient.Stats()
+	read := stats.BytesReadData.Int64()
+	written := stats.BytesWrittenData.Int64()
+
+	ch <- prometheus.MustNewConstMetric(torrentsDesc, prometheus.GaugeValue, float64(len(tClient.Torrents())))
+	ch <- prometheus.MustNewConstMetric(peersDesc, prometheus.GaugeValue, float64(stats.ActivePeers))
+	ch <- prometheus.MustNewConstMetric(downloadedDesc, prometheus.CounterValue, float64(read))
+	ch <- prometheus.MustNewConstMetric(uploadedDesc, prometheus.CounterValue, float64(written))
+	ch <- prometheus.MustNewConstMetric(storageUsedDesc, prometheus.GaugeValue, float64(storageUsed()))
+	ch <- prometheus.MustNewConstMetric(storageMaxDesc, prometheus.GaugeValue, settings().StorageLimitGB*1024*1024*1024)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var down, up float64
+	if elapsed := now.Sub(c.lastScrape).Seconds(); !c.lastScrape.IsZero() && elapsed > 0 {
+		down = float64(read-c.lastRead) / elapsed
+		up = float64(written-c.lastWrite) / elapsed
+	}
+	c.lastScrape, c.lastRead, c.lastWrite = now, read, written
+	ch <- prometheus.MustNewConstMetric(downloadRate, prometheus.GaugeValue, down)
+	ch <- prometheus.MustNewConstMetric(uploadRate, prometheus.GaugeValue, up)
+}
+
+// requireMetricsAccess lets Prometheus scrape with METRICS_TOKEN as a bearer
+// token and admins look at the metrics with their session. Without a token
+// only admins can read them.
+func requireMetricsAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && cfg.MetricsToken != "" &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MetricsToken)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if u, ok := sessionUser(r); ok && u.Role == roleAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// upstreamTransport records latency and errors for requests to service.
+type upstreamTransport struct {
+	service string
+	next    http.RoundTripper
+}
+
+func newUpstreamClient(service string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: upstreamTransport{service: service, next: http.DefaultTransport},
+	}
+}
+
+func (t upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start)
+	upstreamDuration.WithLabelValues(t.service).Observe(elapsed.Seconds())
+
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	if err != nil || status >= 400 {
+		upstreamErrors.WithLabelValues(t.service).Inc()
+	}
+	// Only the path is logged, TMDB passes the API key in the query.
+	slog.DebugContext(req.Context(), "Upstream request", "service", t.service, "path", req.URL.Path, "status", status, "duration", elapsed)
+	return resp, err
+}
+
+// firstByteWriter observes the time to the first body write of a response.
+type firstByteWriter struct {
+	http.ResponseWriter
+	start time.Time
+	once  sync.Once
+}
+
+func (w *firstByteWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		streamFirstByte.Observe(time.Since(w.start).Seconds())
+	})
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *firstByteWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestUpstreamClientCountsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	client := newUpstreamClient("prowlarr", 0)
+	before := testutil.ToFloat64(upstreamErrors.WithLabelValues("prowlarr"))
+
+	for _, path := range []string{"/ok", "/fail"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if got := testutil.ToFloat64(upstreamErrors.WithLabelValues("prowlarr")) - before; got != 1 {
+		t.Errorf("errors = %v, want 1", got)
+	}
+}
+
+func histogramCount(t *testing.T, h prometheus.Histogram) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestStreamFirstByteSkipsLookupErrors(t *testing.T) {
+	tor := useTorrentClient(t)
+	store = openTestStore(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/stream/{hash}/{fileIdx}", handleStream)
+
+	before := histogramCount(t, streamFirstByte)
+	for _, target := range []string{
+		"/api/stream/nothex/0",
+		"/api/stream/" + strings.Repeat("a", 40) + "/0",
+		"/api/stream/" + tor.InfoHash().HexString() + "/7",
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code < 400 {
+			t.Errorf("%s: status = %d", target, rec.Code)
+		}
+	}
+	if got := histogramCount(t, streamFirstByte) - before; got != 0 {
+		t.Errorf("failed lookups observed %d times", got)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/stream/"+tor.InfoHash().HexString()+"/0", nil)
+	req.Header.Set("Range", "bytes=0-15")
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("stream: status = %d", rec.Code)
+	}
+	if got := histogramCount(t, streamFirstByte) - before; got != 1 {
+		t.Errorf("stream observed %d times, want 1", got)
+	}
+}
+
+func TestMetricsRequireTokenOrAdmin(t *testing.T) {
+	store = openTestStore(t)
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+	cfg = Config{MetricsToken: "scrape-me"}
+
+	admin, _ := store.CreateUser("admin", "hash", roleAdmin)
+	viewer, _ := store.CreateUser("viewer", "hash", roleViewer)
+	session := func(u user) *http.Cookie {
+		token := fmt.Sprintf("token-%d", u.Id)
+		if err := store.CreateSession(hashToken(token), u.Id, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		return &http.Cookie{Name: sessionCookieName, Value: token}
+	}
+
+	h := requireMetricsAccess(promhttp.Handler())
+	tests := []struct {
+		name   string
+		auth   string
+		cookie *http.Cookie
+		want   int
+	}{
+		{"anonymous", "", nil, http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", nil, http.StatusUnauthorized},
+		{"token", "Bearer scrape-me", nil, http.StatusOK},
+		{"viewer", "", session(viewer), http.StatusUnauthorized},
+		{"admin", "", session(admin), http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		if tt.cookie != nil {
+			req.AddCookie(tt.cookie)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	// An empty token must not match an empty bearer.
+	cfg.MetricsToken = ""
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("empty token: status = %d", rec.Code)
+	}
+}
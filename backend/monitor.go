@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	monitorInterval = 1 * time.Hour
+	// monitorLookback limits how long after airing an episode keeps being
+	// searched for.
+	monitorLookback = 14 * 24 * time.Hour
+)
+
+type AvailabilityRepository interface {
+	GetEpisodeAvailability(tmdbId, season, episode int) (episodeAvailability, bool, error)
+	SaveEpisodeAvailability(a episodeAvailability) error
+	ListEpisodeAvailability(tmdbId int) ([]episodeAvailability, error)
+}
+
+type episodeAvailability struct {
+	TmdbId       int        `json:"tmdbId"`
+	Season       int        `json:"season"`
+	Episode      int        `json:"episode"`
+	AirDate      string     `json:"airDate"`
+	FirstSeenAt  time.Time  `json:"firstSeenAt"`
+	AvailableAt  *time.Time `json:"availableAt"`
+	ReleaseTitle string     `json:"releaseTitle,omitempty"`
+	Resolution   int        `json:"resolution,omitempty"`
+	Seeders      int        `json:"seeders,omitempty"`
+}
+
+var notifiers []Notifier
+
+func initMonitor() {
+	notifiers = configuredNotifiers()
+	go func() {
+		ticker := time.NewTicker(monitorInterval)
+		for range ticker.C {
+			checkFollowedShows(context.Background())
+		}
+	}()
+}
+
+// followedShows maps each show on a watchlist or with watch history to the
+// users following it.
+func followedShows() (map[int][]int64, error) {
+	watchlisted, err := store.ListAllItems(listWatchlist, "show")
+	if err != nil {
+		return nil, err
+	}
+	started, err := store.ListStartedShows()
+	if err != nil {
+		return nil, err
+	}
+
+	shows := map[int][]int64{}
+	seen := map[listEntry]bool{}
+	for _, e := range append(watchlisted, started...) {
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+		shows[e.TmdbId] = append(shows[e.TmdbId], e.UserId)
+	}
+	return shows, nil
+}
+
+func checkFollowedShows(ctx context.Context) {
+	shows, err := followedShows()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load followed shows", "err", err)
+		return
+	}
+
+	for showId, userIds := range shows {
+		if err := checkShow(ctx, showId, userIds); err != nil {
+			slog.WarnContext(ctx, "Failed to check show", "show", showId, "err", err)
+		}
+	}
+}
+
+// checkShow searches sources for every episode that aired within the
+// lookback and has none yet. Whole seasons can drop at once and an episode
+// may only find a source after later ones did, so the last aired episode
+// alone is not enough.
+func checkShow(ctx context.Context, showId int, userIds []int64) error {
+	enCtx := englishContext(ctx)
+	show, err := metadata.Show(enCtx, showId)
+	if err != nil {
+		return err
+	}
+	last := show.LastEpisodeToAir
+	if last == nil || last.SeasonNumber == 0 {
+		return nil
+	}
+
+	known, err := store.ListEpisodeAvailability(showId)
+	if err != nil {
+		return err
+	}
+	seen := map[[2]int]episodeAvailability{}
+	for _, a := range known {
+		seen[[2]int{a.Season, a.Episode}] = a
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-monitorLookback)
+	for season := last.SeasonNumber; season > 0; season-- {
+		s, err := metadata.Season(enCtx, showId, season)
+		if err != nil {
+			return err
+		}
+		pastLookback := false
+		for _, ep := range s.Episodes {
+			aired, err := time.Parse(tmdbDateLayout, ep.AirDate)
+			if err != nil || aired.After(now) {
+				continue
+			}
+			if aired.Before(cutoff) {
+				pastLookback = true
+				continue
+			}
+			avail, ok := seen[[2]int{season, ep.EpisodeNumber}]
+			if ok && avail.AvailableAt != nil {
+				continue
+			}
+			if !ok {
+				avail = episodeAvailability{
+					TmdbId:      showId,
+					Season:      season,
+					Episode:     ep.EpisodeNumber,
+					AirDate:     ep.AirDate,
+					FirstSeenAt: now,
+				}
+				slog.InfoContext(ctx, "Episode aired", "show", show.Name, "season", season, "episode", ep.EpisodeNumber, "air_date", ep.AirDate)
+			}
+			if err := checkEpisode(ctx, show, avail, userIds); err != nil {
+				return err
+			}
+		}
+		if pastLookback {
+			break
+		}
+	}
+	return nil
+}
+
+func checkEpisode(ctx context.Context, show tmdbShow, avail episodeAvailability, userIds []int64) error {
+	imdbId := strings.TrimPrefix(show.ExternalIds.ImdbId, "tt")
+	if imdbId != "" {
+		results := getProwlarrEpisodeSources(ctx, imdbId, avail.Season, avail.Episode, show.Name, show.OriginalName)
+		if best, found := bestResult(results, settings().Quality); found {
+			now := time.Now()
+			avail.AvailableAt = &now
+			avail.ReleaseTitle = best.Title
+			avail.Resolution = best.Resolution
+			avail.Seeders = best.Seeders
+		}
+	}
+
+	if err := store.SaveEpisodeAvailability(avail); err != nil {
+		return err
+	}
+	if avail.AvailableAt != nil {
+		slog.InfoContext(ctx, "Episode available", "show", show.Name, "season", avail.Season, "episode", avail.Episode, "release", avail.ReleaseTitle)
+		notifyAvailable(ctx, show.Name, avail, userIds)
+	}
+	return nil
+}
+
+// notifyAvailable tells every follower of the show through their own
+// targets. The server-wide notifiers get one notification naming all of
+// them.
+func notifyAvailable(ctx context.Context, showName string, avail episodeAvailability, userIds []int64) {
+	quality := "unknown quality"
+	if avail.Resolution > 0 {
+		quality = fmt.Sprintf("%dp", avail.Resolution)
+	}
+	n := notification{
+		Title:   fmt.Sprintf("%s S%02dE%02d is available", showName, avail.Season, avail.Episode),
+		Message: fmt.Sprintf("A %s source with %d seeders is ready to stream: %s", quality, avail.Seeders, avail.ReleaseTitle),
+		ShowId:  avail.TmdbId,
+		Season:  avail.Season,
+		Episode: avail.Episode,
+	}
+
+	var usernames []string
+	for _, id := range userIds {
+		u, _, err := store.GetUser(id)
+		if err != nil {
+			continue
+		}
+		usernames = append(usernames, u.Username)
+
+		targets, err := store.GetNotifyTargets(id)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to load notification targets", "user", u.Username, "err", err)
+			continue
+		}
+		personal := n
+		personal.Users = []string{u.Username}
+		deliverNotification(ctx, targets.notifiers(), personal)
+	}
+
+	n.Users = usernames
+	deliverNotification(ctx, notifiers, n)
+}
+
+func handleEpisodeAvailability(w http.ResponseWriter, r *http.Request) {
+	tmdbId, err := strconv.Atoi(r.URL.Query().Get("tmdbId"))
+	if err != nil {
+		http.Error(w, "Invalid tmdbId", http.StatusBadRequest)
+		return
+	}
+	list, err := store.ListEpisodeAvailability(tmdbId)
+	if err != nil {
+		http.Error(w, "Failed to load availability", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, list)
+}
+
+const availabilityColumns = "tmdb_id, season, episode, air_date, first_seen_at, available_at, release_title, resolution, seeders"
+
+func (s *Store) GetEpisodeAvailability(tmdbId, season, episode int) (episodeAvailability, bool, error) {
+	row := s.db.QueryRow(
+		"SELECT "+availabilityColumns+" FROM episode_availability WHERE tmdb_id = ? AND season = ? AND episode = ?",
+		tmdbId, season, episode,
+	)
+	a, err := scanAvailability(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return episodeAvailability{}, false, nil
+	}
+	if err != nil {
+		return episodeAvailability{}, false, err
+	}
+	return a, true, nil
+}
+
+func (s *Store) SaveEpisodeAvailability(a episodeAvailability) error {
+	var availableAt *int64
+	if a.AvailableAt != nil {
+		ts := a.AvailableAt.Unix()
+		availableAt = &ts
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO episode_availability (`+availabilityColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(tmdb_id, season, episode) DO UPDATE SET
+			air_date = excluded.air_date,
+			available_at = COALESCE(episode_availability.available_at, excluded.available_at),
+			release_title = excluded.release_title,
+			resolution = excluded.resolution,
+			seeders = excluded.seeders`,
+		a.TmdbId, a.Season, a.Episode, a.AirDate, a.FirstSeenAt.Unix(), availableAt, a.ReleaseTitle, a.Resolution, a.Seeders,
+	)
+	return err
+}
+
+func (s *Store) ListEpisodeAvailability(tmdbId int) ([]episodeAvailability, error) {
+	rows, err := s.db.Query(
+		"SELECT "+availabilityColumns+" FROM episode_availability WHERE tmdb_id = ? ORDER BY season, episode",
+		tmdbId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []episodeAvailability{}
+	for rows.Next() {
+		a, err := scanAvailability(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+func scanAvailability(row interface{ Scan(...any) error }) (episodeAvailability, error) {
+	var a episodeAvailability
+	var firstSeen int64
+	var availableAt sql.NullInt64
+	err := row.Scan(&a.TmdbId, &a.Season, &a.Episode, &a.AirDate, &firstSeen, &availableAt, &a.ReleaseTitle, &a.Resolution, &a.Seeders)
+	a.FirstSeenAt = time.Unix(firstSeen, 0)
+	if availableAt.Valid {
+		t := time.Unix(availableAt.Int64, 0)
+		a.AvailableAt = &t
+	}
+	return a, err
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode"
+)
+
+// setupMonitorTest points the TMDB and Prowlarr clients at local stand-in
+// servers and opens a fresh store.
+func setupMonitorTest(t *testing.T, tmdb, prowlarr http.Handler) {
+	t.Helper()
+
+	tmdbServer := httptest.NewServer(tmdb)
+	prowlarrServer := httptest.NewServer(prowlarr)
+	t.Cleanup(tmdbServer.Close)
+	t.Cleanup(prowlarrServer.Close)
+
+	oldCfg, oldStore, oldBase, oldNotifiers, oldUserClient := cfg, store, tmdbBaseURL, notifiers, userNotifyClient
+	t.Cleanup(func() {
+		cfg, store, tmdbBaseURL, notifiers, userNotifyClient = oldCfg, oldStore, oldBase, oldNotifiers, oldUserClient
+		tmdbCache.Clear()
+	})
+	// The users' webhooks below are local test servers.
+	userNotifyClient = notifyClient
+
+	cfg = Config{TmdbApiKey: "test"}
+	useSettings(t, liveSettings{
+		ProwlarrBaseUrl: prowlarrServer.URL,
+		ProwlarrApiKey:  "test",
+		Quality:         qualityProfile{PreferredResolution: 1080, MaxResolution: 2160, MinSeeders: 5},
+	})
+	tmdbBaseURL = tmdbServer.URL
+	tmdbCache.Clear()
+	store = openTestStore(t)
+}
+
+type recordingWebhook struct {
+	mu       sync.Mutex
+	received []notification
+}
+
+func (h *recordingWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var n notification
+	json.NewDecoder(r.Body).Decode(&n)
+	h.mu.Lock()
+	h.received = append(h.received, n)
+	h.mu.Unlock()
+}
+
+func (h *recordingWebhook) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.received)
+}
+
+type fakeEpisode struct {
+	season, episode int
+	airDate         string
+}
+
+func daysAgo(n int) string {
+	return time.Now().AddDate(0, 0, -n).Format(tmdbDateLayout)
+}
+
+// fakeShowHandler serves a show called "Show Name" and its seasons. The last
+// of episodes is the last episode to air.
+func fakeShowHandler(showId int, episodes ...fakeEpisode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		last := episodes[len(episodes)-1]
+		if r.URL.Path == fmt.Sprintf("/tv/%d", showId) {
+			writeJSON(w, map[string]any{
+				"name": "Show Name",
+				"last_episode_to_air": map[string]any{
+					"season_number":  last.season,
+					"episode_number": last.episode,
+					"air_date":       last.airDate,
+				},
+				"external_ids": map[string]any{"imdb_id": "tt123"},
+			})
+			return
+		}
+		var season int
+		if _, err := fmt.Sscanf(r.URL.Path, fmt.Sprintf("/tv/%d/season/%%d", showId), &season); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		var eps []map[string]any
+		for _, ep := range episodes {
+			if ep.season == season {
+				eps = append(eps, map[string]any{"season_number": ep.season, "episode_number": ep.episode, "air_date": ep.airDate})
+			}
+		}
+		writeJSON(w, map[string]any{"season_number": season, "episodes": eps})
+	}
+}
+
+func TestCheckFollowedShowsNotifiesOnce(t *testing.T) {
+	tmdb := fakeShowHandler(42,
+		fakeEpisode{1, 1, daysAgo(30)},
+		fakeEpisode{1, 2, daysAgo(1)},
+	)
+
+	var searches int
+	var searchMu sync.Mutex
+	prowlarr := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		searchMu.Lock()
+		searches++
+		searchMu.Unlock()
+		writeJSON(w, []map[string]any{
+			{"title": "Show.Name.S01E02.720p.WEB", "guid": "a", "seeders": 80.0},
+			{"title": "Show.Name.S01E02.1080p.WEB", "guid": "b", "seeders": 20.0},
+			{"title": "Show.Name.S01E02.1080p.x265", "guid": "c", "seeders": 2.0},
+		})
+	})
+
+	setupMonitorTest(t, tmdb, prowlarr)
+
+	hook := &recordingWebhook{}
+	hookServer := httptest.NewServer(hook)
+	defer hookServer.Close()
+	notifiers = []Notifier{webhookNotifier{url: hookServer.URL}}
+
+	u, err := store.CreateUser("alice", "hash", roleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.AddToList(u.Id, listWatchlist, "show", 42)
+
+	checkFollowedShows(context.Background())
+
+	if hook.count() != 1 {
+		t.Fatalf("got %d notifications, want 1", hook.count())
+	}
+	n := hook.received[0]
+	if n.ShowId != 42 || n.Season != 1 || n.Episode != 2 {
+		t.Errorf("notification for %d S%dE%d", n.ShowId, n.Season, n.Episode)
+	}
+	if len(n.Users) != 1 || n.Users[0] != "alice" {
+		t.Errorf("users = %v", n.Users)
+	}
+
+	avail, ok, err := store.GetEpisodeAvailability(42, 1, 2)
+	if err != nil || !ok || avail.AvailableAt == nil {
+		t.Fatalf("availability = %+v, %v, %v", avail, ok, err)
+	}
+	if avail.Resolution != 1080 || avail.ReleaseTitle != "Show.Name.S01E02.1080p.WEB" {
+		t.Errorf("picked %q (%dp), want the 1080p release with enough seeders", avail.ReleaseTitle, avail.Resolution)
+	}
+	if _, ok, _ := store.GetEpisodeAvailability(42, 1, 1); ok {
+		t.Error("episode older than the lookback was checked")
+	}
+
+	searchesBefore := searches
+	checkFollowedShows(context.Background())
+	if hook.count() != 1 {
+		t.Fatalf("got %d notifications after second check, want 1", hook.count())
+	}
+	if searches != searchesBefore {
+		t.Errorf("indexer searched again for an episode that is already available")
+	}
+}
+
+func TestCheckFollowedShowsFindsEveryNewEpisode(t *testing.T) {
+	// A whole season dropped two days ago, the first episode has no source
+	// until the second check.
+	tmdb := fakeShowHandler(42,
+		fakeEpisode{1, 1, daysAgo(400)},
+		fakeEpisode{2, 1, daysAgo(2)},
+		fakeEpisode{2, 2, daysAgo(2)},
+		fakeEpisode{2, 3, daysAgo(2)},
+	)
+
+	var mu sync.Mutex
+	released := map[int]bool{2: true, 3: true}
+	searched := map[int]int{}
+	prowlarr := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		mu.Lock()
+		defer mu.Unlock()
+		for ep := 1; ep <= 3; ep++ {
+			if !strings.Contains(query, fmt.Sprintf("{Episode:%d}", ep)) && !strings.HasSuffix(query, fmt.Sprintf("S02E%02d", ep)) {
+				continue
+			}
+			searched[ep]++
+			if released[ep] {
+				writeJSON(w, []map[string]any{{"title": fmt.Sprintf("Show.Name.S02E%02d.1080p.WEB", ep), "guid": fmt.Sprint(ep), "seeders": 50.0}})
+				return
+			}
+		}
+		writeJSON(w, []map[string]any{})
+	})
+
+	setupMonitorTest(t, tmdb, prowlarr)
+
+	server := &recordingWebhook{}
+	serverHook := httptest.NewServer(server)
+	defer serverHook.Close()
+	notifiers = []Notifier{webhookNotifier{url: serverHook.URL}}
+
+	// alice follows the show, carol has a target but does not.
+	personal := map[string]*recordingWebhook{}
+	for _, name := range []string{"alice", "carol"} {
+		hook := &recordingWebhook{}
+		hookServer := httptest.NewServer(hook)
+		defer hookServer.Close()
+		personal[name] = hook
+
+		u, err := store.CreateUser(name, "hash", roleViewer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetNotifyTargets(u.Id, notifyTargets{WebhookUrl: hookServer.URL}); err != nil {
+			t.Fatal(err)
+		}
+		if name == "alice" {
+			store.AddToList(u.Id, listWatchlist, "show", 42)
+		}
+	}
+
+	episodes := func(h *recordingWebhook) []int {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		var out []int
+		for _, n := range h.received {
+			out = append(out, n.Episode)
+		}
+		slices.Sort(out)
+		return out
+	}
+
+	checkFollowedShows(context.Background())
+	if got := episodes(personal["alice"]); !slices.Equal(got, []int{2, 3}) {
+		t.Errorf("alice was told about episodes %v, want [2 3]", got)
+	}
+	for _, n := range personal["alice"].received {
+		if len(n.Users) != 1 || n.Users[0] != "alice" {
+			t.Errorf("personal notification users = %v", n.Users)
+		}
+	}
+	if got := episodes(server); !slices.Equal(got, []int{2, 3}) {
+		t.Errorf("server notifiers got episodes %v, want [2 3]", got)
+	}
+	if personal["carol"].count() != 0 {
+		t.Errorf("carol got %d notifications for a show not followed", personal["carol"].count())
+	}
+	if a, ok, _ := store.GetEpisodeAvailability(42, 2, 1); !ok || a.AvailableAt != nil {
+		t.Errorf("episode without a source = %+v, %v", a, ok)
+	}
+
+	mu.Lock()
+	released[1] = true
+	clear(searched)
+	mu.Unlock()
+
+	checkFollowedShows(context.Background())
+	if got := episodes(personal["alice"]); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("alice was told about episodes %v, want [1 2 3]", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if searched[2] != 0 || searched[3] != 0 {
+		t.Errorf("available episodes searched again: %v", searched)
+	}
+}
+
+func TestCheckFollowedShowsFindsSeasonPacks(t *testing.T) {
+	tmdb := fakeShowHandler(9, fakeEpisode{1, 1, daysAgo(1)}, fakeEpisode{1, 2, daysAgo(1)})
+	// Only a pack of the whole season was released, episode searches find
+	// nothing.
+	prowlarr := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		if query == "Show Name S01" || query == "{ImdbId:123}{Season:1}" {
+			writeJSON(w, []map[string]any{{"title": "Show.Name.S01.1080p.WEB", "guid": "pack", "seeders": 40.0}})
+			return
+		}
+		writeJSON(w, []map[string]any{})
+	})
+
+	setupMonitorTest(t, tmdb, prowlarr)
+
+	hook := &recordingWebhook{}
+	hookServer := httptest.NewServer(hook)
+	defer hookServer.Close()
+	notifiers = []Notifier{webhookNotifier{url: hookServer.URL}}
+
+	u, _ := store.CreateUser("dana", "hash", roleViewer)
+	store.AddToList(u.Id, listWatchlist, "show", 9)
+
+	checkFollowedShows(context.Background())
+
+	if hook.count() != 2 {
+		t.Fatalf("got %d notifications, want one per episode", hook.count())
+	}
+	for ep := 1; ep <= 2; ep++ {
+		avail, ok, _ := store.GetEpisodeAvailability(9, 1, ep)
+		if !ok || avail.AvailableAt == nil || avail.ReleaseTitle != "Show.Name.S01.1080p.WEB" {
+			t.Errorf("episode %d availability = %+v, %v", ep, avail, ok)
+		}
+	}
+}
+
+func TestCheckFollowedShowsWaitsForGoodSource(t *testing.T) {
+	tmdb := fakeShowHandler(7, fakeEpisode{3, 1, daysAgo(1)})
+	prowlarr := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, []map[string]any{
+			{"title": "Show.Name.S03E01.1080p.WEB", "guid": "a", "seeders": 1.0},
+		})
+	})
+
+	setupMonitorTest(t, tmdb, prowlarr)
+
+	hook := &recordingWebhook{}
+	hookServer := httptest.NewServer(hook)
+	defer hookServer.Close()
+	notifiers = []Notifier{webhookNotifier{url: hookServer.URL}}
+
+	u, _ := store.CreateUser("bob", "hash", roleViewer)
+	store.SaveProgress(progress{
+		mediaKey:  mediaKey{Type: "episode", TmdbId: 7, Season: 2, Episode: 10},
+		Position:  100,
+		Duration:  100,
+		Watched:   true,
+		UpdatedAt: time.Now(),
+		userId:    u.Id,
+	})
+
+	checkFollowedShows(context.Background())
+
+	if hook.count() != 0 {
+		t.Fatalf("got %d notifications, want none", hook.count())
+	}
+	avail, ok, _ := store.GetEpisodeAvailability(7, 3, 1)
+	if !ok {
+		t.Fatal("aired episode was not recorded")
+	}
+	if avail.AvailableAt != nil {
+		t.Error("episode marked available without an acceptable source")
+	}
+}
+
+func TestNotifyTargetsValidate(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+	cfg = Config{}
+
+	valid := notifyTargets{WebhookUrl: "https://example.com/hook", NtfyUrl: "http://ntfy.local/shows"}
+	if err := valid.validate(); err != nil {
+		t.Errorf("valid targets: %v", err)
+	}
+	for _, tt := range []notifyTargets{
+		{WebhookUrl: "ftp://example.com"},
+		{NtfyUrl: "ntfy.sh/topic"},
+		{Email: "alice@example.com"},
+		{WebhookUrl: "http://127.0.0.1:8080/api/admin/reload"},
+		{WebhookUrl: "http://localhost/hook"},
+		{NtfyUrl: "http://[::1]/topic"},
+		{NtfyUrl: "http://10.0.0.5/topic"},
+		{WebhookUrl: "http://169.254.169.254/latest/meta-data"},
+	} {
+		if err := tt.validate(); err == nil {
+			t.Errorf("%+v: expected an error", tt)
+		}
+	}
+
+	cfg = Config{SmtpHost: "mail.local", SmtpFrom: "kiroshi@example.com"}
+	if err := (notifyTargets{Email: "alice@example.com"}).validate(); err != nil {
+		t.Errorf("email with SMTP set up: %v", err)
+	}
+	if err := (notifyTargets{Email: "Alice <alice@example.com>"}).validate(); err == nil {
+		t.Error("expected an error for a display name")
+	}
+}
+
+func TestUserNotifiersOnlyReachPublicHosts(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits.Add(1) }))
+	defer server.Close()
+
+	// Stored targets skip validation here, as a name that later resolves to
+	// a local address would. The connection is still refused.
+	for _, n := range (notifyTargets{WebhookUrl: server.URL, NtfyUrl: server.URL}).notifiers() {
+		if err := n.Notify(context.Background(), notification{Title: "t"}); err == nil || !strings.Contains(err.Error(), "not a public address") {
+			t.Errorf("%T: err = %v", n, err)
+		}
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("local server was called %d times", n)
+	}
+
+	// The server's own notifiers may point at internal hosts.
+	if err := (webhookNotifier{url: server.URL}).Notify(context.Background(), notification{}); err != nil || hits.Load() != 1 {
+		t.Errorf("server webhook: %v, %d hits", err, hits.Load())
+	}
+}
+
+func TestNtfyNotifier(t *testing.T) {
+	var gotTitle, gotAuth, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTitle = r.Header.Get("Title")
+		gotAuth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+	}))
+	defer server.Close()
+
+	n := ntfyNotifier{url: server.URL + "/kiroshi", token: "secret"}
+	err := n.Notify(context.Background(), notification{Title: "New episode", Message: "S01E01 is out"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotTitle != "New episode" || gotAuth != "Bearer secret" || gotBody != "S01E01 is out" {
+		t.Errorf("got title %q, auth %q, body %q", gotTitle, gotAuth, gotBody)
+	}
+}
+
+func TestWebhookNotifierReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := (webhookNotifier{url: server.URL}).Notify(context.Background(), notification{}); err == nil {
+		t.Fatal("expected an error for a 500 response")
+	}
+}
+
+func TestSmtpNotifier(t *testing.T) {
+	addr, messages := startFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	n := smtpNotifier{
+		addr: net.JoinHostPort(host, port),
+		host: host,
+		from: "kiroshi@example.com",
+		to:   []string{"alice@example.com"},
+	}
+	// Original titles are often not ASCII.
+	title := "Die Brücke – Transit in den Tod S01E01 is available"
+	err := n.Notify(context.Background(), notification{Title: title, Message: "Go watch it"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg, "Go watch it") {
+			t.Errorf("unexpected message:\n%s", msg)
+		}
+		var subject string
+		for _, line := range strings.Split(msg, "\r\n") {
+			if v, ok := strings.CutPrefix(line, "Subject: "); ok {
+				subject = v
+			}
+		}
+		decoded, err := new(mime.WordDecoder).DecodeHeader(subject)
+		if err != nil || decoded != title || strings.ContainsFunc(subject, func(r rune) bool { return r > unicode.MaxASCII }) {
+			t.Errorf("Subject: %s decodes to %q, %v", subject, decoded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+// startFakeSMTP runs a minimal SMTP server that accepts every message and
+// sends the DATA section to the returned channel.
+func startFakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+		reply("220 localhost ESMTP")
+
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "DATA"):
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				messages <- data.String()
+				reply("250 queued")
+			case strings.HasPrefix(cmd, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), messages
+}
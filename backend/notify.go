@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const notifyTimeout = 10 * time.Second
+
+var (
+	notifyClient = &http.Client{Timeout: notifyTimeout}
+	// userNotifyClient sends to URLs that users entered. It only connects to
+	// public addresses, so viewers cannot make the server call internal hosts.
+	// The check runs on every connection, which also covers redirects and
+	// names that resolve differently later.
+	userNotifyClient = &http.Client{
+		Timeout: notifyTimeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{Timeout: notifyTimeout, Control: dialPublicOnly}).DialContext,
+		},
+	}
+)
+
+type NotifyRepository interface {
+	GetNotifyTargets(userId int64) (notifyTargets, error)
+	SetNotifyTargets(userId int64, t notifyTargets) error
+}
+
+type notification struct {
+	Title   string   `json:"title"`
+	Message string   `json:"message"`
+	ShowId  int      `json:"showId"`
+	Season  int      `json:"season"`
+	Episode int      `json:"episode"`
+	Users   []string `json:"users"`
+}
+
+// Notifier delivers a notification to an external service.
+type Notifier interface {
+	Notify(ctx context.Context, n notification) error
+}
+
+func configuredNotifiers() []Notifier {
+	var out []Notifier
+	if cfg.NotifyWebhookUrl != "" {
+		out = append(out, webhookNotifier{url: cfg.NotifyWebhookUrl})
+	}
+	if cfg.NotifyNtfyUrl != "" {
+		out = append(out, ntfyNotifier{url: cfg.NotifyNtfyUrl, token: cfg.NotifyNtfyToken})
+	}
+	if smtpConfigured() && len(cfg.SmtpTo) > 0 {
+		out = append(out, smtpNotifier{
+			addr:     net.JoinHostPort(cfg.SmtpHost, cfg.SmtpPort),
+			host:     cfg.SmtpHost,
+			username: cfg.SmtpUsername,
+			password: cfg.SmtpPassword,
+			from:     cfg.SmtpFrom,
+			to:       cfg.SmtpTo,
+		})
+	}
+	return out
+}
+
+// notifyTargets are where a user's own notifications go. Email is sent with
+// the server's SMTP settings.
+type notifyTargets struct {
+	WebhookUrl string `json:"webhookUrl"`
+	NtfyUrl    string `json:"ntfyUrl"`
+	Email      string `json:"email"`
+}
+
+func smtpConfigured() bool {
+	return cfg.SmtpHost != "" && cfg.SmtpFrom != ""
+}
+
+func (t notifyTargets) validate() error {
+	for _, u := range []string{t.WebhookUrl, t.NtfyUrl} {
+		if u == "" {
+			continue
+		}
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%q is not an http(s) URL", u)
+		}
+		host := parsed.Hostname()
+		if ip := net.ParseIP(host); strings.EqualFold(host, "localhost") || (ip != nil && !isPublicIP(ip)) {
+			return fmt.Errorf("%q is not a public address", u)
+		}
+	}
+	if t.Email != "" {
+		if !smtpConfigured() {
+			return errors.New("email notifications are not set up on this server")
+		}
+		if addr, err := mail.ParseAddress(t.Email); err != nil || addr.Address != t.Email {
+			return fmt.Errorf("%q is not an email address", t.Email)
+		}
+	}
+	return nil
+}
+
+func (t notifyTargets) notifiers() []Notifier {
+	var out []Notifier
+	if t.WebhookUrl != "" {
+		out = append(out, webhookNotifier{url: t.WebhookUrl, client: userNotifyClient})
+	}
+	if t.NtfyUrl != "" {
+		out = append(out, ntfyNotifier{url: t.NtfyUrl, client: userNotifyClient})
+	}
+	if t.Email != "" && smtpConfigured() {
+		out = append(out, smtpNotifier{
+			addr:     net.JoinHostPort(cfg.SmtpHost, cfg.SmtpPort),
+			host:     cfg.SmtpHost,
+			username: cfg.SmtpUsername,
+			password: cfg.SmtpPassword,
+			from:     cfg.SmtpFrom,
+			to:       []string{t.Email},
+		})
+	}
+	return out
+}
+
+func deliverNotification(ctx context.Context, to []Notifier, n notification) {
+	for _, notifier := range to {
+		ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		if err := notifier.Notify(ctx, n); err != nil {
+			slog.WarnContext(ctx, "Notification failed", "err", err)
+		}
+		cancel()
+	}
+}
+
+func handleGetNotifyTargets(w http.ResponseWriter, r *http.Request) {
+	u, _ := currentUser(r)
+	t, err := store.GetNotifyTargets(u.Id)
+	if err != nil {
+		http.Error(w, "Failed to load notification settings", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, t)
+}
+
+// handleUpdateNotifyTargets sets where the signed in user is told about new
+// episodes of the shows they follow. Empty values turn a target off.
+func handleUpdateNotifyTargets(w http.ResponseWriter, r *http.Request) {
+	u, _ := currentUser(r)
+
+	var t notifyTargets
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := t.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := store.SetNotifyTargets(u.Id, t); err != nil {
+		http.Error(w, "Failed to save notification settings", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, t)
+}
+
+func (s *Store) GetNotifyTargets(userId int64) (notifyTargets, error) {
+	var t notifyTargets
+	err := s.db.QueryRow(
+		"SELECT webhook_url, ntfy_url, email FROM notify_targets WHERE user_id = ?", userId,
+	).Scan(&t.WebhookUrl, &t.NtfyUrl, &t.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notifyTargets{}, nil
+	}
+	return t, err
+}
+
+func (s *Store) SetNotifyTargets(userId int64, t notifyTargets) error {
+	_, err := s.db.Exec(
+		`INSERT INTO notify_targets (user_id, webhook_url, ntfy_url, email) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			webhook_url = excluded.webhook_url,
+			ntfy_url = excluded.ntfy_url,
+			email = excluded.email`,
+		userId, t.WebhookUrl, t.NtfyUrl, t.Email,
+	)
+	return err
+}
+
+// webhookNotifier posts the notification as JSON.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n webhookNotifier) Notify(ctx context.Context, msg notification) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doNotifyRequest(n.client, req)
+}
+
+// ntfyNotifier publishes to an ntfy-compatible topic URL.
+type ntfyNotifier struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (n ntfyNotifier) Notify(ctx context.Context, msg notification) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, strings.NewReader(msg.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", msg.Title)
+	req.Header.Set("Tags", "tv")
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	return doNotifyRequest(n.client, req)
+}
+
+func doNotifyRequest(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = notifyClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %d", req.URL.Host, resp.StatusCode)
+	}
+	return nil
+}
+
+type smtpNotifier struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (n smtpNotifier) Notify(ctx context.Context, msg notification) error {
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, n.host)
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", n.from)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Title))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	body.WriteString(msg.Message)
+	body.WriteString("\r\n")
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(n.addr, auth, n.from, n.to, body.Bytes())
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+const precacheCheckInterval = 5 * time.Minute
+
+type precacheWindow struct {
+	start, end time.Duration
+}
+
+// parsePrecacheWindow parses "HH:MM-HH:MM". Windows may wrap past midnight,
+// e.g. "23:00-05:00".
+func parsePrecacheWindow(s string) (precacheWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return precacheWindow{}, fmt.Errorf("invalid precache window %q, expected HH:MM-HH:MM", s)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return precacheWindow{}, fmt.Errorf("invalid precache window start: %w", err)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return precacheWindow{}, fmt.Errorf("invalid precache window end: %w", err)
+	}
+	return precacheWindow{
+		start: time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+		end:   time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute,
+	}, nil
+}
+
+// currentStart returns the start of the window occurrence that contains now.
+func (w precacheWindow) currentStart(now time.Time) (time.Time, bool) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+
+	if w.start <= w.end {
+		if offset >= w.start && offset < w.end {
+			return midnight.Add(w.start), true
+		}
+		return time.Time{}, false
+	}
+	if offset >= w.start {
+		return midnight.Add(w.start), true
+	}
+	if offset < w.end {
+		return midnight.AddDate(0, 0, -1).Add(w.start), true
+	}
+	return time.Time{}, false
+}
+
+func initPrecache() {
+	if cfg.PrecacheWindow == "" {
+		return
+	}
+	window, err := parsePrecacheWindow(cfg.PrecacheWindow)
+	if err != nil {
+		fatal("Invalid PRECACHE_WINDOW", "err", err)
+	}
+	slog.Info("Pre-caching watchlist episodes", "window", cfg.PrecacheWindow)
+	go precacheRoutine(window)
+}
+
+func precacheRoutine(window precacheWindow) {
+	var lastRun time.Time
+	ticker := time.NewTicker(precacheCheckInterval)
+	for range ticker.C {
+		start, ok := window.currentStart(time.Now())
+		if !ok || !lastRun.Before(start) {
+			continue
+		}
+		lastRun = time.Now()
+		precacheWatchlist(context.Background())
+	}
+}
+
+func precacheWatchlist(ctx context.Context) {
+	items, err := store.ListAllItems(listWatchlist, "show")
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load watchlists", "err", err)
+		return
+	}
+
+	histories := map[int64][]progress{}
+	done := map[string]bool{}
+
+	for _, item := range items {
+		history, ok := histories[item.UserId]
+		if !ok {
+			history, err = store.ListProgress(item.UserId)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load watch history", "user_id", item.UserId, "err", err)
+				continue
+			}
+			histories[item.UserId] = history
+		}
+
+		var showHistory []progress
+		for _, p := range history {
+			if p.Type == "episode" && p.TmdbId == item.TmdbId {
+				showHistory = append(showHistory, p)
+			}
+		}
+
+		next, ok, err := nextEpisode(ctx, item.TmdbId, showHistory)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to compute next episode", "show", item.TmdbId, "err", err)
+			continue
+		}
+		if !ok || !next.Aired {
+			continue
+		}
+
+		key := fmt.Sprintf("%d-%d-%d", next.TmdbId, next.Season, next.Episode)
+		if done[key] {
+			continue
+		}
+		done[key] = true
+
+		if err := precacheEpisode(ctx, next); err != nil {
+			slog.WarnContext(ctx, "Failed to pre-cache episode", "show", next.ShowName, "season", next.Season, "episode", next.Episode, "err", err)
+		}
+	}
+}
+
+func precacheEpisode(ctx context.Context, next upNextEntry) error {
+	show, err := metadata.Show(englishContext(ctx), next.TmdbId)
+	if err != nil {
+		return err
+	}
+	imdbId := strings.TrimPrefix(show.ExternalIds.ImdbId, "tt")
+	if imdbId == "" {
+		return errors.New("show has no IMDb id")
+	}
+
+	results := getProwlarrEpisodeSources(ctx, imdbId, next.Season, next.Episode, show.Name, show.OriginalName)
+	best, ok := bestResult(results, settings().Quality)
+	if !ok {
+		return errors.New("no source matches the quality profile")
+	}
+
+	want := best.Size
+	if cfg.PrecacheMB > 0 && cfg.PrecacheMB*1024*1024 < want {
+		want = cfg.PrecacheMB * 1024 * 1024
+	}
+	if !fitsStorageLimit(want) {
+		return errors.New("not enough room under the storage limit")
+	}
+
+	var t *torrent.Torrent
+	for _, source := range []string{best.Guid, best.Link} {
+		if t, err = resolveAndAdd(ctx, source); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	t.AddTrackers(trackerTiers(settings().Trackers))
+	ih := t.InfoHash().String()
+	trackNewTorrent(t)
+
+	ctx, cancel := context.WithTimeout(ctx, torrentClientTimeout)
+	defer cancel()
+	select {
+	case <-t.GotInfo():
+	case <-ctx.Done():
+		if !hasActiveStreams(ih) {
+			dropTorrent(t, dropMetadataTimeout)
+		}
+		return errors.New("timeout waiting for torrent metadata")
+	}
+
+	_, file := selectFile(t, next.Season, next.Episode)
+	if file == nil {
+		if !hasActiveStreams(ih) {
+			dropTorrent(t, dropNoVideo)
+		}
+		return errors.New("no suitable video file found")
+	}
+
+	saveTorrent(t)
+	updateAccess(ih)
+	pinTorrent(ih, time.Now().Add(settings().PrecacheRetention.Duration))
+
+	if cfg.PrecacheMB > 0 && file.Length() > cfg.PrecacheMB*1024*1024 {
+		pieceLen := t.Info().PieceLength
+		end := int((file.Offset() + cfg.PrecacheMB*1024*1024 + pieceLen - 1) / pieceLen)
+		t.DownloadPieces(file.BeginPieceIndex(), min(end, file.EndPieceIndex()))
+	} else {
+		file.Download()
+	}
+
+	slog.InfoContext(ctx, "Pre-caching episode", "file", file.DisplayPath(), "show", next.ShowName, "season", next.Season, "episode", next.Episode)
+	return nil
+}
+
+func fitsStorageLimit(bytes int64) bool {
+	limit := int64(settings().StorageLimitGB * 1024 * 1024 * 1024)
+	return storageUsed()+bytes <= limit
+}
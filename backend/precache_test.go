@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestParsePrecacheWindow(t *testing.T) {
+	tests := []struct {
+		in         string
+		start, end time.Duration
+	}{
+		{"02:00-06:30", 2 * time.Hour, 6*time.Hour + 30*time.Minute},
+		{" 23:00 - 05:00 ", 23 * time.Hour, 5 * time.Hour},
+		{"00:00-23:59", 0, 23*time.Hour + 59*time.Minute},
+	}
+	for _, tt := range tests {
+		w, err := parsePrecacheWindow(tt.in)
+		if err != nil || w.start != tt.start || w.end != tt.end {
+			t.Errorf("parsePrecacheWindow(%q) = %+v, %v, want %v-%v", tt.in, w, err, tt.start, tt.end)
+		}
+	}
+
+	for _, in := range []string{"", "02:00", "2am-6am", "02:00-25:00", "24:00-06:00", "02:00-06:00-08:00"} {
+		if w, err := parsePrecacheWindow(in); err == nil {
+			t.Errorf("parsePrecacheWindow(%q) = %+v, want an error", in, w)
+		}
+	}
+}
+
+func TestPrecacheWindowCurrentStart(t *testing.T) {
+	at := func(day, hour, min int) time.Time { return time.Date(2024, 3, day, hour, min, 0, 0, time.UTC) }
+
+	tests := []struct {
+		window string
+		now    time.Time
+		want   time.Time
+		ok     bool
+	}{
+		{"02:00-06:00", at(10, 1, 59), time.Time{}, false},
+		{"02:00-06:00", at(10, 2, 0), at(10, 2, 0), true},
+		{"02:00-06:00", at(10, 5, 59), at(10, 2, 0), true},
+		{"02:00-06:00", at(10, 6, 0), time.Time{}, false},
+
+		// Wrapping past midnight, the early hours belong to the previous
+		// day's window.
+		{"23:00-05:00", at(10, 22, 59), time.Time{}, false},
+		{"23:00-05:00", at(10, 23, 0), at(10, 23, 0), true},
+		{"23:00-05:00", at(11, 0, 0), at(10, 23, 0), true},
+		{"23:00-05:00", at(1, 4, 59), time.Date(2024, 2, 29, 23, 0, 0, 0, time.UTC), true},
+		{"23:00-05:00", at(11, 5, 0), time.Time{}, false},
+		{"23:00-05:00", at(11, 12, 0), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		w, err := parsePrecacheWindow(tt.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := w.currentStart(tt.now)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("%s at %s: currentStart = %s, %v, want %s, %v", tt.window, tt.now.Format("Jan 2 15:04"), got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// addEpisodeTorrent adds a 3 MB episode whose data the client does not have.
+func addEpisodeTorrent(t *testing.T) *torrent.Torrent {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "Show.Name.S01E02.1080p.WEB.mkv")
+	if err := os.WriteFile(path, make([]byte, 3<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: 256 << 10}
+	if err := info.BuildFromFilePath(path); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, err := tClient.AddTorrent(&metainfo.MetaInfo{InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ih := tor.InfoHash().String()
+	t.Cleanup(func() {
+		addedAt.Delete(ih)
+		lastAccessed.Delete(ih)
+		pinnedUntil.Delete(ih)
+	})
+	return tor
+}
+
+func TestPrecacheEpisode(t *testing.T) {
+	tmdb := fakeShowHandler(5, fakeEpisode{1, 1, daysAgo(8)}, fakeEpisode{1, 2, daysAgo(1)})
+	var magnet string
+	prowlarr := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, []map[string]any{
+			{"title": "Show.Name.S01E02.1080p.WEB", "guid": magnet, "seeders": 40.0, "size": 3 << 20},
+		})
+	})
+	setupMonitorTest(t, tmdb, prowlarr)
+	useTorrentClient(t)
+	tor := addEpisodeTorrent(t)
+	magnet = "magnet:?xt=urn:btih:" + tor.InfoHash().HexString()
+	ih := tor.InfoHash().String()
+
+	s := settings()
+	s.PrecacheRetention = duration{12 * time.Hour}
+	next := upNextEntry{TmdbId: 5, ShowName: "Show Name", Season: 1, Episode: 2, Aired: true}
+	ctx := context.Background()
+
+	// Nothing is added or pinned without room under the storage limit.
+	s.StorageLimitGB = 1.0 / 1024
+	useSettings(t, s)
+	if err := precacheEpisode(ctx, next); err == nil {
+		t.Fatal("precached an episode over the storage limit")
+	}
+	if _, ok := pinnedUntil.Load(ih); ok {
+		t.Error("torrent pinned over the storage limit")
+	}
+
+	// With PRECACHE_MB only the start of the file is fetched: 1 MB is the
+	// first four 256 KB pieces.
+	s.StorageLimitGB = 1
+	useSettings(t, s)
+	cfg.PrecacheMB = 1
+	if err := precacheEpisode(ctx, next); err != nil {
+		t.Fatal(err)
+	}
+	until, ok := pinnedUntil.Load(ih)
+	if !ok || time.Until(until.(time.Time)) < 11*time.Hour {
+		t.Errorf("pinned until %v, %v, want about 12h from now", until, ok)
+	}
+	if recs, err := store.ListTorrents(); err != nil || len(recs) != 1 || recs[0].InfoHash != ih {
+		t.Errorf("saved torrents = %+v, %v", recs, err)
+	}
+	for i := range tor.NumPieces() {
+		if wanted := tor.PieceState(i).Priority != 0; wanted != (i < 4) {
+			t.Errorf("piece %d wanted = %v", i, wanted)
+		}
+	}
+
+	// Without a limit the whole file is fetched.
+	cfg.PrecacheMB = 0
+	if err := precacheEpisode(ctx, next); err != nil {
+		t.Fatal(err)
+	}
+	for i := range tor.NumPieces() {
+		if tor.PieceState(i).Priority == 0 {
+			t.Errorf("piece %d not wanted", i)
+		}
+	}
+}
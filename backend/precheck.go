@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	precheckMaxItems = 100
+	// A hundred items are about 3 KB of JSON.
+	precheckMaxBodyBytes = 64 * 1024
+	// precheckConcurrency limits title lookups across all requests, a page of
+	// results must flood neither TMDB nor the indexers.
+	precheckConcurrency = 4
+	precheckCacheTTL    = 30 * time.Minute
+	// Prowlarr errors look like empty results, so retry those sooner.
+	precheckEmptyTTL     = 5 * time.Minute
+	precheckCacheEntries = 2000
+)
+
+var (
+	precheckSlots = make(chan struct{}, precheckConcurrency)
+	precheckCalls singleflight.Group
+
+	precheckMu    sync.Mutex
+	precheckCache = map[mediaRef]precheckEntry{}
+	// precheckWaiting counts the callers waiting on each title, so a lookup
+	// still queued for a slot can be dropped once they have all gone.
+	precheckWaiting = map[mediaRef]int{}
+)
+
+var errPrecheckAbandoned = errors.New("precheck abandoned")
+
+// precheckEntry holds the releases an IMDb id-only query found. Only the
+// fields the quality profile looks at are kept, so a profile change applies
+// to cached titles too.
+type precheckEntry struct {
+	results []prowlarrResult
+	expires time.Time
+}
+
+type precheckRequest struct {
+	Items []struct {
+		Type string `json:"type"`
+		Id   int    `json:"id"`
+	} `json:"items"`
+}
+
+// sourceAvailability is the best release for a title under the quality
+// profile. Sources counts all releases, acceptable or not.
+type sourceAvailability struct {
+	Type       string `json:"type"`
+	Id         int    `json:"id"`
+	Available  bool   `json:"available"`
+	Resolution int    `json:"resolution,omitempty"`
+	Seeders    int    `json:"seeders,omitempty"`
+	Sources    int    `json:"sources"`
+	Error      string `json:"error,omitempty"`
+}
+
+func cachedPrecheck(ref mediaRef) ([]prowlarrResult, bool) {
+	precheckMu.Lock()
+	defer precheckMu.Unlock()
+	e, ok := precheckCache[ref]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.results, true
+}
+
+func storePrecheck(ref mediaRef, results []prowlarrResult) {
+	ttl := precheckCacheTTL
+	if len(results) == 0 {
+		ttl = precheckEmptyTTL
+	}
+
+	precheckMu.Lock()
+	defer precheckMu.Unlock()
+	now := time.Now()
+	if len(precheckCache) >= precheckCacheEntries {
+		for k, e := range precheckCache {
+			if now.After(e.expires) {
+				delete(precheckCache, k)
+			}
+		}
+	}
+	if len(precheckCache) >= precheckCacheEntries {
+		// Still full of live entries, start over rather than track recency.
+		clear(precheckCache)
+	}
+	precheckCache[ref] = precheckEntry{results: results, expires: now.Add(ttl)}
+}
+
+// precheckImdbId resolves a title's IMDb id without the "tt" prefix, the form
+// Prowlarr's ImdbId search expects.
+func precheckImdbId(ctx context.Context, ref mediaRef) (string, error) {
+	if ref.Type == "movie" {
+		m, err := metadata.Movie(ctx, ref.Id)
+		return strings.TrimPrefix(m.ImdbId, "tt"), err
+	}
+	s, err := metadata.Show(ctx, ref.Id)
+	return strings.TrimPrefix(s.ExternalIds.ImdbId, "tt"), err
+}
+
+func precheckWait(ref mediaRef, delta int) int {
+	precheckMu.Lock()
+	defer precheckMu.Unlock()
+	n := precheckWaiting[ref] + delta
+	if n == 0 {
+		delete(precheckWaiting, ref)
+	} else {
+		precheckWaiting[ref] = n
+	}
+	return n
+}
+
+// precheckSources returns the releases of a title from the cache or an IMDb
+// id-only Prowlarr query. Concurrent checks of one title share the query, and
+// a query still waiting for a slot is dropped when every caller has gone.
+func precheckSources(ctx context.Context, ref mediaRef) ([]prowlarrResult, error) {
+	for {
+		if results, ok := cachedPrecheck(ref); ok {
+			return results, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		precheckWait(ref, 1)
+		ch := precheckCalls.DoChan(fmt.Sprintf("%s/%d", ref.Type, ref.Id), func() (any, error) {
+			precheckSlots <- struct{}{}
+			defer func() { <-precheckSlots }()
+			if precheckWait(ref, 0) == 0 {
+				return nil, errPrecheckAbandoned
+			}
+
+			// The result is shared, so one caller going away must not cancel it.
+			ctx := context.WithoutCancel(ctx)
+			imdbId, err := precheckImdbId(ctx, ref)
+			if err != nil {
+				return nil, err
+			}
+			var results []prowlarrResult
+			if imdbId != "" {
+				searchType := "movie"
+				if ref.Type == "show" {
+					searchType = "tvsearch"
+				}
+				results = fetchProwlarr(ctx, map[string]string{"type": searchType, "query": fmt.Sprintf("{ImdbId:%s}", imdbId)})
+			}
+
+			kept := make([]prowlarrResult, 0, len(results))
+			for _, r := range results {
+				kept = append(kept, prowlarrResult{Size: r.Size, Seeders: r.Seeders, Resolution: r.Resolution})
+			}
+			storePrecheck(ref, kept)
+			return kept, nil
+		})
+
+		var res singleflight.Result
+		select {
+		case res = <-ch:
+		case <-ctx.Done():
+			precheckWait(ref, -1)
+			return nil, ctx.Err()
+		}
+		precheckWait(ref, -1)
+		if errors.Is(res.Err, errPrecheckAbandoned) {
+			// We joined a lookup just as its last caller left, run our own.
+			continue
+		}
+		results, _ := res.Val.([]prowlarrResult)
+		return results, res.Err
+	}
+}
+
+func precheck(ctx context.Context, ref mediaRef) sourceAvailability {
+	a := sourceAvailability{Type: ref.Type, Id: ref.Id}
+	results, err := precheckSources(ctx, ref)
+	if err != nil && ctx.Err() != nil {
+		return a
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to resolve title for precheck", "type", ref.Type, "id", ref.Id, "err", err)
+		a.Error = "metadata unavailable"
+		return a
+	}
+	a.Sources = len(results)
+	if best, ok := bestResult(results, settings().Quality); ok {
+		a.Available, a.Resolution, a.Seeders = true, best.Resolution, best.Seeders
+	}
+	return a
+}
+
+// handlePrecheck reports whether sources exist for a batch of movies and
+// shows. The response is newline delimited JSON, one object per title in the
+// order the checks finish, so cached titles arrive at once and the rest fill
+// in as Prowlarr answers.
+func handlePrecheck(w http.ResponseWriter, r *http.Request) {
+	var req precheckRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, precheckMaxBodyBytes)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) > precheckMaxItems {
+		http.Error(w, fmt.Sprintf("At most %d items per request", precheckMaxItems), http.StatusBadRequest)
+		return
+	}
+
+	var refs []mediaRef
+	seen := map[mediaRef]bool{}
+	for _, item := range req.Items {
+		ref := mediaRef{item.Type, item.Id}
+		if (ref.Type != "movie" && ref.Type != "show") || ref.Id <= 0 {
+			http.Error(w, "Invalid item type or id", http.StatusBadRequest)
+			return
+		}
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+
+	results := make(chan sourceAvailability, len(refs))
+	for _, ref := range refs {
+		go func() { results <- precheck(r.Context(), ref) }()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	for range refs {
+		select {
+		case a := <-results:
+			if err := enc.Encode(a); err != nil {
+				return
+			}
+			rc.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
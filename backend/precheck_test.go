@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrecheckStreamsCachedResults(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	inFlight, maxInFlight := 0, 0
+	prowlarr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("type") + " " + r.URL.Query().Get("query")
+		mu.Lock()
+		calls[query]++
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		switch query {
+		case "movie {ImdbId:0133093}":
+			w.Write([]byte(`[
+				{"title": "The.Matrix.1999.2160p.UHD", "seeders": 40},
+				{"title": "The.Matrix.1999.1080p.BluRay", "seeders": 120},
+				{"title": "The.Matrix.1999.720p.WEB", "seeders": 2}
+			]`))
+		case "tvsearch {ImdbId:0944947}":
+			w.Write([]byte(`[{"title": "Game.of.Thrones.S01.720p", "seeders": 30}]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	t.Cleanup(prowlarr.Close)
+
+	useMetadata(t, fixtureProvider{dir: "testdata/metadata"})
+	useSettings(t, liveSettings{
+		ProwlarrBaseUrl: prowlarr.URL,
+		Quality:         qualityProfile{PreferredResolution: 1080, MaxResolution: 2160, MinSeeders: 5},
+	})
+	oldSlots := precheckSlots
+	precheckSlots = make(chan struct{}, 1)
+	t.Cleanup(func() {
+		precheckSlots = oldSlots
+		precheckMu.Lock()
+		clear(precheckCache)
+		precheckMu.Unlock()
+	})
+
+	body := `{"items": [{"type": "movie", "id": 603}, {"type": "movie", "id": 604}, {"type": "show", "id": 1399}, {"type": "movie", "id": 603}]}`
+	check := func() map[mediaRef]sourceAvailability {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handlePrecheck(rec, httptest.NewRequest(http.MethodPost, "/api/precheck", strings.NewReader(body)))
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("status = %d, content type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+		}
+		got := map[mediaRef]sourceAvailability{}
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			var a sourceAvailability
+			if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+				t.Fatalf("line %q: %v", scanner.Text(), err)
+			}
+			got[mediaRef{a.Type, a.Id}] = a
+		}
+		if len(got) != 3 {
+			t.Fatalf("got %d titles, want 3: %+v", len(got), got)
+		}
+		return got
+	}
+
+	got := check()
+	if a := got[mediaRef{"movie", 603}]; !a.Available || a.Resolution != 1080 || a.Seeders != 120 || a.Sources != 3 {
+		t.Errorf("movie with sources = %+v", a)
+	}
+	if a := got[mediaRef{"movie", 604}]; a.Available || a.Sources != 0 || a.Error != "" {
+		t.Errorf("movie without sources = %+v", a)
+	}
+	if a := got[mediaRef{"show", 1399}]; !a.Available || a.Resolution != 720 {
+		t.Errorf("show = %+v", a)
+	}
+	mu.Lock()
+	peak := maxInFlight
+	mu.Unlock()
+	if peak != 1 {
+		t.Errorf("max concurrent Prowlarr queries = %d, want 1", peak)
+	}
+
+	check()
+	mu.Lock()
+	defer mu.Unlock()
+	for query, n := range calls {
+		if n != 1 {
+			t.Errorf("%s queried %d times, want cached", query, n)
+		}
+	}
+}
+
+func TestPrecheckRejectsInvalidItems(t *testing.T) {
+	for _, body := range []string{
+		`{"items": [{"type": "episode", "id": 1}]}`,
+		`{"items": [{"type": "movie", "id": 0}]}`,
+		`{"items": [` + strings.TrimSuffix(strings.Repeat(`{"type": "movie", "id": 1},`, precheckMaxItems+1), ",") + `]}`,
+		`not json`,
+		`{"items": [], "padding": "` + strings.Repeat("x", precheckMaxBodyBytes) + `"}`,
+	} {
+		rec := httptest.NewRecorder()
+		handlePrecheck(rec, httptest.NewRequest(http.MethodPost, "/api/precheck", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%.40s: status = %d", body, rec.Code)
+		}
+	}
+}
+
+// countingMetadata records how many title lookups run at once.
+type countingMetadata struct {
+	fixtureProvider
+	mu                  sync.Mutex
+	inFlight, maxFlight int
+	ids                 []int
+}
+
+func (m *countingMetadata) Movie(ctx context.Context, id int) (tmdbMovie, error) {
+	m.mu.Lock()
+	m.inFlight++
+	m.maxFlight = max(m.maxFlight, m.inFlight)
+	m.ids = append(m.ids, id)
+	m.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	m.mu.Lock()
+	m.inFlight--
+	m.mu.Unlock()
+	return tmdbMovie{Id: id, ImdbId: fmt.Sprintf("tt%07d", id)}, nil
+}
+
+func TestPrecheckBoundsMetadataLookups(t *testing.T) {
+	prowlarr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	t.Cleanup(prowlarr.Close)
+
+	m := &countingMetadata{}
+	useMetadata(t, m)
+	useSettings(t, liveSettings{ProwlarrBaseUrl: prowlarr.URL})
+	oldSlots := precheckSlots
+	precheckSlots = make(chan struct{}, 2)
+	t.Cleanup(func() {
+		precheckSlots = oldSlots
+		precheckMu.Lock()
+		clear(precheckCache)
+		precheckMu.Unlock()
+	})
+
+	var items []string
+	for id := 1; id <= 20; id++ {
+		items = append(items, fmt.Sprintf(`{"type": "movie", "id": %d}`, id))
+	}
+	rec := httptest.NewRecorder()
+	handlePrecheck(rec, httptest.NewRequest(http.MethodPost, "/api/precheck", strings.NewReader(`{"items": [`+strings.Join(items, ",")+`]}`)))
+	if rec.Code != http.StatusOK || strings.Count(rec.Body.String(), "\n") != 20 {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.maxFlight > 2 {
+		t.Errorf("max concurrent metadata lookups = %d, want at most 2", m.maxFlight)
+	}
+}
+
+func TestPrecheckDropsLookupsNobodyWaitsFor(t *testing.T) {
+	prowlarr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	t.Cleanup(prowlarr.Close)
+
+	m := &countingMetadata{}
+	useMetadata(t, m)
+	useSettings(t, liveSettings{ProwlarrBaseUrl: prowlarr.URL})
+	t.Cleanup(func() {
+		precheckMu.Lock()
+		clear(precheckCache)
+		precheckMu.Unlock()
+	})
+
+	// Hold every slot so all lookups queue. The channel is not swapped out
+	// because abandoned lookups may still be releasing theirs at cleanup.
+	for range cap(precheckSlots) {
+		precheckSlots <- struct{}{}
+	}
+
+	var items []string
+	for id := 1; id <= 5; id++ {
+		items = append(items, fmt.Sprintf(`{"type": "movie", "id": %d}`, id))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/api/precheck", strings.NewReader(`{"items": [`+strings.Join(items, ",")+`]}`))
+		handlePrecheck(httptest.NewRecorder(), req)
+	}()
+
+	// Another caller still wants movie 2.
+	shared := make(chan error, 1)
+	go func() {
+		_, err := precheckSources(context.Background(), mediaRef{"movie", 2})
+		shared <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+	// The per-title goroutines notice the cancellation on their own.
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		precheckMu.Lock()
+		n := len(precheckWaiting)
+		precheckMu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d titles still waited on after cancelling", n)
+		}
+	}
+	for range cap(precheckSlots) {
+		<-precheckSlots
+	}
+
+	if err := <-shared; err != nil {
+		t.Fatalf("shared lookup: %v", err)
+	}
+	// Let the abandoned lookups get their slots and give up.
+	time.Sleep(50 * time.Millisecond)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.ids) != 1 || m.ids[0] != 2 {
+		t.Errorf("looked up %v, want only the title someone still waits for", m.ids)
+	}
+	precheckMu.Lock()
+	defer precheckMu.Unlock()
+	if len(precheckWaiting) != 0 {
+		t.Errorf("waiting = %v, want empty", precheckWaiting)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const continueWatchingLimit = 20
+
+type ProgressRepository interface {
+	SaveProgress(p progress) error
+	GetProgress(userId int64, key mediaKey) (progress, bool, error)
+	ListProgress(userId int64) ([]progress, error)
+	ListStartedShows() ([]listEntry, error)
+}
+
+// mediaKey identifies a TMDB movie or episode. For episodes TmdbId is the id
+// of the show.
+type mediaKey struct {
+	Type    string `json:"type"`
+	TmdbId  int    `json:"tmdbId"`
+	Season  int    `json:"season,omitempty"`
+	Episode int    `json:"episode,omitempty"`
+}
+
+type progress struct {
+	mediaKey
+	Position  float64   `json:"position"`
+	Duration  float64   `json:"duration"`
+	Watched   bool      `json:"watched"`
+	InfoHash  string    `json:"infoHash,omitempty"`
+	FileIdx   int       `json:"fileIdx"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	userId int64
+}
+
+func parseMediaKey(r *http.Request) (mediaKey, error) {
+	q := r.URL.Query()
+	key := mediaKey{Type: q.Get("type")}
+	key.TmdbId, _ = strconv.Atoi(q.Get("tmdbId"))
+	key.Season, _ = strconv.Atoi(q.Get("season"))
+	key.Episode, _ = strconv.Atoi(q.Get("episode"))
+	return key, key.validate()
+}
+
+func (k mediaKey) validate() error {
+	if k.TmdbId <= 0 {
+		return errors.New("missing tmdbId")
+	}
+	switch k.Type {
+	case "movie":
+		if k.Season != 0 || k.Episode != 0 {
+			return errors.New("movies have no season or episode")
+		}
+	case "episode":
+		if k.Season < 0 || k.Episode <= 0 {
+			return errors.New("missing season or episode")
+		}
+	default:
+		return errors.New("invalid type")
+	}
+	return nil
+}
+
+func handleGetProgress(w http.ResponseWriter, r *http.Request) {
+	key, err := parseMediaKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u, _ := currentUser(r)
+	p, ok, err := store.GetProgress(u.Id, key)
+	if err != nil {
+		http.Error(w, "Failed to load progress", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		writeJSON(w, nil)
+		return
+	}
+	writeJSON(w, p)
+}
+
+func handlePutProgress(w http.ResponseWriter, r *http.Request) {
+	var p progress
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := p.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if p.Position < 0 || p.Duration <= 0 {
+		http.Error(w, "Invalid position or duration", http.StatusBadRequest)
+		return
+	}
+
+	u, _ := currentUser(r)
+	p.userId = u.Id
+	p.Watched = p.Position/p.Duration >= cfg.WatchedThreshold
+	p.UpdatedAt = time.Now()
+
+	prev, hadPrev, err := store.GetProgress(u.Id, p.mediaKey)
+	if err != nil {
+		http.Error(w, "Failed to load progress", http.StatusInternalServerError)
+		return
+	}
+	if err := store.SaveProgress(p); err != nil {
+		http.Error(w, "Failed to save progress", http.StatusInternalServerError)
+		return
+	}
+	// The stored row keeps an earlier watched flag, answer with that.
+	saved, _, err := store.GetProgress(u.Id, p.mediaKey)
+	if err != nil {
+		http.Error(w, "Failed to load progress", http.StatusInternalServerError)
+		return
+	}
+	emitPlaybackEvents(u, prev, hadPrev, p)
+	writeJSON(w, saved)
+}
+
+// handleContinueWatching lists titles the user started but did not finish,
+// most recent first, with at most one episode per show.
+func handleContinueWatching(w http.ResponseWriter, r *http.Request) {
+	u, _ := currentUser(r)
+	all, err := store.ListProgress(u.Id)
+	if err != nil {
+		http.Error(w, "Failed to load progress", http.StatusInternalServerError)
+		return
+	}
+
+	seenShows := map[int]bool{}
+	out := []progress{}
+	for _, p := range all {
+		if p.Type == "episode" {
+			if seenShows[p.TmdbId] {
+				continue
+			}
+			seenShows[p.TmdbId] = true
+		}
+		if p.Watched || p.Position <= 0 {
+			continue
+		}
+		out = append(out, p)
+		if len(out) >= continueWatchingLimit {
+			break
+		}
+	}
+	writeJSON(w, out)
+}
+
+// SaveProgress upserts a progress entry. Once an item has been watched it
+// stays watched, rewatching only moves the position.
+func (s *Store) SaveProgress(p progress) error {
+	_, err := s.db.Exec(
+		`INSERT INTO progress (user_id, media_type, tmdb_id, season, episode, position, duration, watched, info_hash, file_idx, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, media_type, tmdb_id, season, episode) DO UPDATE SET
+			position = excluded.position,
+			duration = excluded.duration,
+			watched = MAX(progress.watched, excluded.watched),
+			info_hash = excluded.info_hash,
+			file_idx = excluded.file_idx,
+			updated_at = excluded.updated_at`,
+		p.userId, p.Type, p.TmdbId, p.Season, p.Episode, p.Position, p.Duration, p.Watched, p.InfoHash, p.FileIdx, p.UpdatedAt.Unix(),
+	)
+	return err
+}
+
+const progressColumns = "user_id, media_type, tmdb_id, season, episode, position, duration, watched, info_hash, file_idx, updated_at"
+
+func (s *Store) GetProgress(userId int64, key mediaKey) (progress, bool, error) {
+	row := s.db.QueryRow(
+		"SELECT "+progressColumns+" FROM progress WHERE user_id = ? AND media_type = ? AND tmdb_id = ? AND season = ? AND episode = ?",
+		userId, key.Type, key.TmdbId, key.Season, key.Episode,
+	)
+	p, err := scanProgress(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return progress{}, false, nil
+	}
+	if err != nil {
+		return progress{}, false, err
+	}
+	return p, true, nil
+}
+
+func (s *Store) ListProgress(userId int64) ([]progress, error) {
+	rows, err := s.db.Query("SELECT "+progressColumns+" FROM progress WHERE user_id = ? ORDER BY updated_at DESC", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []progress
+	for rows.Next() {
+		p, err := scanProgress(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func scanProgress(row interface{ Scan(...any) error }) (progress, error) {
+	var p progress
+	var updatedAt int64
+	err := row.Scan(&p.userId, &p.Type, &p.TmdbId, &p.Season, &p.Episode, &p.Position, &p.Duration, &p.Watched, &p.InfoHash, &p.FileIdx, &updatedAt)
+	p.UpdatedAt = time.Unix(updatedAt, 0)
+	return p, err
+}
+
+func (s *Store) ListStartedShows() ([]listEntry, error) {
+	rows, err := s.db.Query("SELECT DISTINCT user_id, tmdb_id FROM progress WHERE media_type = 'episode'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []listEntry
+	for rows.Next() {
+		var e listEntry
+		if err := rows.Scan(&e.UserId, &e.TmdbId); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
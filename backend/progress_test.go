@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useProgressStore(t *testing.T) user {
+	t.Helper()
+	store = openTestStore(t)
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+	cfg.WatchedThreshold = 0.9
+
+	u, err := store.CreateUser("alice", "hash", roleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func serveAs(u user, h http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), userCtxKey{}, u))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestPutProgressKeepsWatched(t *testing.T) {
+	u := useProgressStore(t)
+
+	put := func(position float64) progress {
+		t.Helper()
+		body := fmt.Sprintf(`{"type": "movie", "tmdbId": 603, "position": %g, "duration": 100, "infoHash": "abc", "fileIdx": 1}`, position)
+		rec := serveAs(u, handlePutProgress, http.MethodPut, "/api/progress", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		var p progress
+		if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		stored, ok, err := store.GetProgress(u.Id, p.mediaKey)
+		if err != nil || !ok || stored.Watched != p.Watched || stored.Position != p.Position {
+			t.Fatalf("response %+v differs from stored %+v (%v, %v)", p, stored, ok, err)
+		}
+		return p
+	}
+
+	if p := put(89); p.Watched {
+		t.Errorf("89%% watched: %+v", p)
+	}
+	if p := put(90); !p.Watched {
+		t.Errorf("90%% not watched: %+v", p)
+	}
+	// Rewatching moves the position but the title stays watched.
+	if p := put(10); !p.Watched || p.Position != 10 || p.InfoHash != "abc" || p.FileIdx != 1 {
+		t.Errorf("rewatch: %+v", p)
+	}
+}
+
+func TestPutProgressValidates(t *testing.T) {
+	u := useProgressStore(t)
+	for _, body := range []string{
+		`not json`,
+		`{"type": "movie", "position": 1, "duration": 100}`,
+		`{"type": "movie", "tmdbId": 603, "season": 1, "position": 1, "duration": 100}`,
+		`{"type": "episode", "tmdbId": 1399, "season": 1, "position": 1, "duration": 100}`,
+		`{"type": "movie", "tmdbId": 603, "position": -1, "duration": 100}`,
+		`{"type": "movie", "tmdbId": 603, "position": 1, "duration": 0}`,
+	} {
+		if rec := serveAs(u, handlePutProgress, http.MethodPut, "/api/progress", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", body, rec.Code)
+		}
+	}
+}
+
+func TestContinueWatching(t *testing.T) {
+	u := useProgressStore(t)
+	other, err := store.CreateUser("bob", "hash", roleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	save := func(userId int64, key mediaKey, position float64, watched bool, ago time.Duration) {
+		t.Helper()
+		p := progress{mediaKey: key, Position: position, Duration: 100, Watched: watched, UpdatedAt: now.Add(-ago), userId: userId}
+		if err := store.SaveProgress(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	movie := func(id int) mediaKey { return mediaKey{Type: "movie", TmdbId: id} }
+	episode := func(show, season, ep int) mediaKey {
+		return mediaKey{Type: "episode", TmdbId: show, Season: season, Episode: ep}
+	}
+
+	save(u.Id, movie(1), 40, false, 5*time.Hour)
+	save(u.Id, movie(2), 95, true, time.Hour)
+	save(u.Id, movie(3), 0, false, time.Hour)
+	save(u.Id, episode(10, 1, 1), 30, false, 4*time.Hour)
+	save(u.Id, episode(10, 1, 2), 20, false, 2*time.Hour)
+	save(u.Id, episode(11, 1, 3), 95, true, 3*time.Hour)
+	save(u.Id, episode(11, 1, 2), 50, false, 6*time.Hour)
+	save(other.Id, movie(4), 50, false, 0)
+
+	rec := serveAs(u, handleContinueWatching, http.MethodGet, "/api/continue-watching", "")
+	var got []progress
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	// Most recent first, one entry per show, and a show whose latest episode
+	// was finished is left out.
+	want := []mediaKey{episode(10, 1, 2), movie(1)}
+	if len(got) != len(want) {
+		t.Fatalf("continue watching = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].mediaKey != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i].mediaKey, want[i])
+		}
+	}
+}
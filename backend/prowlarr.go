@@ -1,9 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -13,6 +14,8 @@ import (
 	"time"
 )
 
+var prowlarrClient = newUpstreamClient("prowlarr", 8*time.Second)
+
 var (
 	cleanRegexYear        = regexp.MustCompile(`\b\d{4}\b`)
 	cleanRegexCountry     = regexp.MustCompile(`\b(us|uk|au|ca)\b`)
@@ -141,21 +144,21 @@ func mapToResult(item map[string]any) prowlarrResult {
 	}
 }
 
-func fetchProwlarr(params map[string]string) []prowlarrResult {
-	u, _ := url.Parse(strings.TrimRight(cfg.ProwlarrBaseUrl, "/") + "/api/v1/search")
+func fetchProwlarr(ctx context.Context, params map[string]string) []prowlarrResult {
+	s := settings()
+	u, _ := url.Parse(strings.TrimRight(s.ProwlarrBaseUrl, "/") + "/api/v1/search")
 	q := u.Query()
 	for k, v := range params {
 		q.Set(k, v)
 	}
 	u.RawQuery = q.Encode()
 
-	client := &http.Client{Timeout: 8 * time.Second}
-	req, _ := http.NewRequest("GET", u.String(), nil)
-	req.Header.Set("X-Api-Key", cfg.ProwlarrApiKey)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	req.Header.Set("X-Api-Key", s.ProwlarrApiKey)
 
-	resp, err := client.Do(req)
+	resp, err := prowlarrClient.Do(req)
 	if err != nil {
-		log.Printf("[prowlarr] fetch error: %v", err)
+		slog.ErrorContext(ctx, "Prowlarr search failed", "err", err)
 		return nil
 	}
 	defer resp.Body.Close()
@@ -190,59 +193,66 @@ func namedGroup(re *regexp.Regexp, s string) map[string]string {
 	return result
 }
 
-func getProwlarrMovie(imdbId, title, year string) []prowlarrResult {
-	targetClean := cleanTitle(title)
-
+// getProwlarrMovie searches by IMDb id and by each of titles, e.g. the
+// English and the original title, so releases named in either are found.
+func getProwlarrMovie(ctx context.Context, imdbId, year string, titles ...string) []prowlarrResult {
 	var mu sync.Mutex
 	var idResults, textResults []prowlarrResult
 	var wg sync.WaitGroup
 
-	wg.Add(2)
+	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		r := fetchProwlarr(map[string]string{"type": "movie", "query": fmt.Sprintf("{ImdbId:%s}", imdbId)})
+		r := fetchProwlarr(ctx, map[string]string{"type": "movie", "query": fmt.Sprintf("{ImdbId:%s}", imdbId)})
 		mu.Lock()
 		idResults = r
 		mu.Unlock()
 	}()
-	go func() {
-		defer wg.Done()
-		r := fetchProwlarr(map[string]string{"type": "movie", "query": fmt.Sprintf("%s %s", title, year)})
-		var filtered []prowlarrResult
-		for _, item := range r {
-			groups := namedGroup(movieRegex, item.Title)
-			if groups == nil {
-				continue
-			}
-			if groups["year"] != "" && groups["year"] != year {
-				continue
-			}
-			if cleanTitle(groups["title"]) == targetClean {
-				filtered = append(filtered, item)
-			}
+
+	searched := map[string]bool{}
+	for _, title := range titles {
+		targetClean := cleanTitle(title)
+		if targetClean == "" || searched[targetClean] {
+			continue
 		}
-		mu.Lock()
-		textResults = filtered
-		mu.Unlock()
-	}()
+		searched[targetClean] = true
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := fetchProwlarr(ctx, map[string]string{"type": "movie", "query": fmt.Sprintf("%s %s", title, year)})
+			var filtered []prowlarrResult
+			for _, item := range r {
+				groups := namedGroup(movieRegex, item.Title)
+				if groups == nil {
+					continue
+				}
+				if groups["year"] != "" && groups["year"] != year {
+					continue
+				}
+				if cleanTitle(groups["title"]) == targetClean {
+					filtered = append(filtered, item)
+				}
+			}
+			mu.Lock()
+			textResults = append(textResults, filtered...)
+			mu.Unlock()
+		}()
+	}
 	wg.Wait()
 
 	return deduplicateResults(append(idResults, textResults...))
 }
 
-func getProwlarrEpisode(imdbId, title string, season, episode int) []prowlarrResult {
-	targetClean := cleanTitle(title)
-	sStr := fmt.Sprintf("%02d", season)
-	eStr := fmt.Sprintf("%02d", episode)
-
+func getProwlarrEpisode(ctx context.Context, imdbId string, season, episode int, titles ...string) []prowlarrResult {
 	var mu sync.Mutex
 	var idResults, textResults []prowlarrResult
 	var wg sync.WaitGroup
 
-	wg.Add(2)
+	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		r := fetchProwlarr(map[string]string{
+		r := fetchProwlarr(ctx, map[string]string{
 			"type":  "tvsearch",
 			"query": fmt.Sprintf("{ImdbId:%s}{Season:%d}{Episode:%d}", imdbId, season, episode),
 		})
@@ -250,85 +260,154 @@ func getProwlarrEpisode(imdbId, title string, season, episode int) []prowlarrRes
 		idResults = r
 		mu.Unlock()
 	}()
+
+	searched := map[string]bool{}
+	for _, title := range titles {
+		targetClean := cleanTitle(title)
+		if targetClean == "" || searched[targetClean] {
+			continue
+		}
+		searched[targetClean] = true
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := fetchProwlarr(ctx, map[string]string{
+				"type":  "tvsearch",
+				"query": fmt.Sprintf("%s S%02dE%02d", title, season, episode),
+			})
+			var filtered []prowlarrResult
+			for _, item := range r {
+				groups := namedGroup(tvRegex, item.Title)
+				if groups == nil {
+					continue
+				}
+				if s, _ := strconv.Atoi(groups["season"]); s != season {
+					continue
+				}
+				if e, _ := strconv.Atoi(groups["episode"]); e != episode {
+					continue
+				}
+				if cleanTitle(groups["title"]) == targetClean {
+					filtered = append(filtered, item)
+				}
+			}
+			mu.Lock()
+			textResults = append(textResults, filtered...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return deduplicateResults(append(idResults, textResults...))
+}
+
+func getProwlarrSeason(ctx context.Context, imdbId string, season int, titles ...string) []prowlarrResult {
+	var mu sync.Mutex
+	var idResults, textResults []prowlarrResult
+	var wg sync.WaitGroup
+
+	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		r := fetchProwlarr(map[string]string{
+		r := fetchProwlarr(ctx, map[string]string{
 			"type":  "tvsearch",
-			"query": fmt.Sprintf("%s S%sE%s", title, sStr, eStr),
+			"query": fmt.Sprintf("{ImdbId:%s}{Season:%d}", imdbId, season),
 		})
-		var filtered []prowlarrResult
-		for _, item := range r {
-			groups := namedGroup(tvRegex, item.Title)
-			if groups == nil {
-				continue
-			}
-			if s, _ := strconv.Atoi(groups["season"]); s != season {
-				continue
-			}
-			if e, _ := strconv.Atoi(groups["episode"]); e != episode {
-				continue
-			}
-			if cleanTitle(groups["title"]) == targetClean {
-				filtered = append(filtered, item)
-			}
-		}
 		mu.Lock()
-		textResults = filtered
+		idResults = r
 		mu.Unlock()
 	}()
+
+	searched := map[string]bool{}
+	for _, title := range titles {
+		targetClean := cleanTitle(title)
+		if targetClean == "" || searched[targetClean] {
+			continue
+		}
+		searched[targetClean] = true
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := fetchProwlarr(ctx, map[string]string{
+				"type":  "tvsearch",
+				"query": fmt.Sprintf("%s S%02d", title, season),
+			})
+			var filtered []prowlarrResult
+			for _, item := range r {
+				if tvRegex.MatchString(item.Title) {
+					continue
+				}
+				groups := namedGroup(seasonPackRegex, item.Title)
+				if groups == nil {
+					continue
+				}
+				if s, _ := strconv.Atoi(groups["season"]); s != season {
+					continue
+				}
+				if cleanTitle(groups["title"]) == targetClean {
+					filtered = append(filtered, item)
+				}
+			}
+			mu.Lock()
+			textResults = append(textResults, filtered...)
+			mu.Unlock()
+		}()
+	}
 	wg.Wait()
 
 	return deduplicateResults(append(idResults, textResults...))
 }
 
-func getProwlarrSeason(imdbId, title string, season int) []prowlarrResult {
-	targetClean := cleanTitle(title)
-	sStr := fmt.Sprintf("%02d", season)
-
-	var mu sync.Mutex
-	var idResults, textResults []prowlarrResult
+// getProwlarrEpisodeSources combines single episode releases with season
+// packs that contain the episode.
+func getProwlarrEpisodeSources(ctx context.Context, imdbId string, season, episode int, titles ...string) []prowlarrResult {
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var seasonResults, episodeResults []prowlarrResult
 
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		r := fetchProwlarr(map[string]string{
-			"type":  "tvsearch",
-			"query": fmt.Sprintf("{ImdbId:%s}{Season:%d}", imdbId, season),
-		})
+		r := getProwlarrSeason(ctx, imdbId, season, titles...)
 		mu.Lock()
-		idResults = r
+		seasonResults = r
 		mu.Unlock()
 	}()
 	go func() {
 		defer wg.Done()
-		r := fetchProwlarr(map[string]string{
-			"type":  "tvsearch",
-			"query": fmt.Sprintf("%s S%s", title, sStr),
-		})
-		var filtered []prowlarrResult
-		for _, item := range r {
-			if tvRegex.MatchString(item.Title) {
-				continue
-			}
-			groups := namedGroup(seasonPackRegex, item.Title)
-			if groups == nil {
-				continue
-			}
-			if s, _ := strconv.Atoi(groups["season"]); s != season {
-				continue
-			}
-			if cleanTitle(groups["title"]) == targetClean {
-				filtered = append(filtered, item)
-			}
-		}
+		r := getProwlarrEpisode(ctx, imdbId, season, episode, titles...)
 		mu.Lock()
-		textResults = filtered
+		episodeResults = r
 		mu.Unlock()
 	}()
 	wg.Wait()
+	return append(seasonResults, episodeResults...)
+}
 
-	return deduplicateResults(append(idResults, textResults...))
+// releaseTitles returns the English and the original title of a movie or a
+// show. Releases are named after one of them, the title shown to the user may
+// be a translation no release uses.
+func releaseTitles(ctx context.Context, mediaType string, tmdbId int) []string {
+	if tmdbId <= 0 {
+		return nil
+	}
+	ctx = englishContext(ctx)
+	if mediaType == "movie" {
+		m, err := metadata.Movie(ctx, tmdbId)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to fetch release titles", "type", mediaType, "id", tmdbId, "err", err)
+			return nil
+		}
+		return []string{m.Title, m.OriginalTitle}
+	}
+	s, err := metadata.Show(ctx, tmdbId)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to fetch release titles", "type", mediaType, "id", tmdbId, "err", err)
+		return nil
+	}
+	return []string{s.Name, s.OriginalName}
 }
 
 func handleIndexer(w http.ResponseWriter, r *http.Request) {
@@ -337,6 +416,7 @@ func handleIndexer(w http.ResponseWriter, r *http.Request) {
 	imdbId := strings.TrimPrefix(q.Get("imdbId"), "tt")
 	title := q.Get("title")
 	year := q.Get("year")
+	tmdbId, _ := strconv.Atoi(q.Get("tmdbId"))
 	season, _ := strconv.Atoi(q.Get("season"))
 	episode, _ := strconv.Atoi(q.Get("episode"))
 
@@ -353,34 +433,20 @@ func handleIndexer(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Missing year parameter", http.StatusBadRequest)
 			return
 		}
-		results = getProwlarrMovie(imdbId, title, year)
+		titles := append(releaseTitles(r.Context(), mediaType, tmdbId), title, q.Get("originalTitle"))
+		results = getProwlarrMovie(r.Context(), imdbId, year, titles...)
 	case "episode":
-		var wg sync.WaitGroup
-		var mu sync.Mutex
-		var seasonResults, episodeResults []prowlarrResult
-
-		wg.Add(2)
-		go func() {
-			defer wg.Done()
-			r := getProwlarrSeason(imdbId, title, season)
-			mu.Lock()
-			seasonResults = r
-			mu.Unlock()
-		}()
-		go func() {
-			defer wg.Done()
-			r := getProwlarrEpisode(imdbId, title, season, episode)
-			mu.Lock()
-			episodeResults = r
-			mu.Unlock()
-		}()
-		wg.Wait()
-		results = append(seasonResults, episodeResults...)
+		titles := append(releaseTitles(r.Context(), mediaType, tmdbId), title, q.Get("originalTitle"))
+		results = getProwlarrEpisodeSources(r.Context(), imdbId, season, episode, titles...)
 	default:
 		http.Error(w, "Invalid type parameter", http.StatusBadRequest)
 		return
 	}
 
+	if tmdbId > 0 {
+		results = append(libraryResults(mediaType, tmdbId, season, episode), results...)
+	}
+
 	if results == nil {
 		results = []prowlarrResult{}
 	}
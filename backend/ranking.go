@@ -0,0 +1,66 @@
+package main
+
+import "sort"
+
+// qualityProfile decides which indexer results are acceptable and in what
+// order they should be tried when a source is picked automatically.
+type qualityProfile struct {
+	PreferredResolution int     `json:"preferredResolution"`
+	MaxResolution       int     `json:"maxResolution"`
+	MinSeeders          int     `json:"minSeeders"`
+	MaxSizeGB           float64 `json:"maxSizeGB"`
+}
+
+func (p qualityProfile) accepts(r prowlarrResult) bool {
+	if r.Seeders < p.MinSeeders {
+		return false
+	}
+	if p.MaxResolution > 0 && r.Resolution > p.MaxResolution {
+		return false
+	}
+	if p.MaxSizeGB > 0 && float64(r.Size) > p.MaxSizeGB*1024*1024*1024 {
+		return false
+	}
+	return true
+}
+
+// resolutionDistance ranks resolutions by how close they are to the preferred
+// one, preferring higher over lower at equal distance. Unknown resolutions
+// always rank last.
+func (p qualityProfile) resolutionDistance(res int) int {
+	if res == 0 {
+		return 1 << 30
+	}
+	d := res - p.PreferredResolution
+	if d < 0 {
+		return -d*2 + 1
+	}
+	return d * 2
+}
+
+// rankResults returns the acceptable results, best first.
+func rankResults(results []prowlarrResult, p qualityProfile) []prowlarrResult {
+	var ranked []prowlarrResult
+	for _, r := range results {
+		if p.accepts(r) {
+			ranked = append(ranked, r)
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		di, dj := p.resolutionDistance(ranked[i].Resolution), p.resolutionDistance(ranked[j].Resolution)
+		if di != dj {
+			return di < dj
+		}
+		return ranked[i].Seeders > ranked[j].Seeders
+	})
+	return ranked
+}
+
+func bestResult(results []prowlarrResult, p qualityProfile) (prowlarrResult, bool) {
+	ranked := rankResults(results, p)
+	if len(ranked) == 0 {
+		return prowlarrResult{}, false
+	}
+	return ranked[0], true
+}
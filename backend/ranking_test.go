@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+const gb = 1024 * 1024 * 1024
+
+func TestQualityProfileAccepts(t *testing.T) {
+	p := qualityProfile{PreferredResolution: 1080, MaxResolution: 1080, MinSeeders: 5, MaxSizeGB: 10}
+	tests := []struct {
+		name string
+		r    prowlarrResult
+		want bool
+	}{
+		{"within limits", prowlarrResult{Seeders: 5, Resolution: 1080, Size: 10 * gb}, true},
+		{"unknown resolution", prowlarrResult{Seeders: 5, Size: gb}, true},
+		{"too few seeders", prowlarrResult{Seeders: 4, Resolution: 720, Size: gb}, false},
+		{"resolution too high", prowlarrResult{Seeders: 50, Resolution: 2160, Size: gb}, false},
+		{"too large", prowlarrResult{Seeders: 50, Resolution: 1080, Size: 10*gb + 1}, false},
+	}
+	for _, tt := range tests {
+		if got := p.accepts(tt.r); got != tt.want {
+			t.Errorf("%s: accepts(%+v) = %v, want %v", tt.name, tt.r, got, tt.want)
+		}
+	}
+
+	// Zero limits leave resolution and size unbounded.
+	if !(qualityProfile{}).accepts(prowlarrResult{Resolution: 4320, Size: 500 * gb}) {
+		t.Error("empty profile rejected a result")
+	}
+}
+
+func TestRankResults(t *testing.T) {
+	results := []prowlarrResult{
+		{Title: "unknown", Seeders: 900},
+		{Title: "480p", Resolution: 480, Seeders: 100},
+		{Title: "720p", Resolution: 720, Seeders: 10},
+		{Title: "1080p few", Resolution: 1080, Seeders: 3},
+		{Title: "1080p many", Resolution: 1080, Seeders: 30},
+		{Title: "1440p", Resolution: 1440, Seeders: 10},
+		{Title: "2160p", Resolution: 2160, Seeders: 200},
+	}
+	titles := func(rs []prowlarrResult) []string {
+		var out []string
+		for _, r := range rs {
+			out = append(out, r.Title)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name string
+		p    qualityProfile
+		want []string
+	}{
+		{
+			"closest resolution first, then seeders",
+			qualityProfile{PreferredResolution: 1080},
+			[]string{"1080p many", "1080p few", "1440p", "720p", "480p", "2160p", "unknown"},
+		},
+		{
+			"higher wins at equal distance",
+			qualityProfile{PreferredResolution: 1080, MinSeeders: 5},
+			[]string{"1080p many", "1440p", "720p", "480p", "2160p", "unknown"},
+		},
+		{
+			"capped resolution",
+			qualityProfile{PreferredResolution: 2160, MaxResolution: 1080},
+			[]string{"1080p many", "1080p few", "720p", "480p", "unknown"},
+		},
+		{
+			"nothing acceptable",
+			qualityProfile{MinSeeders: 1000},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := titles(rankResults(results, tt.p)); !slices.Equal(got, tt.want) {
+				t.Errorf("rankResults = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if best, ok := bestResult(results, qualityProfile{PreferredResolution: 720}); !ok || best.Title != "720p" {
+		t.Errorf("bestResult = %+v, %v", best, ok)
+	}
+	if _, ok := bestResult(results, qualityProfile{MinSeeders: 1000}); ok {
+		t.Error("bestResult found a result below the seeder minimum")
+	}
+}
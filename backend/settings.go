@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Overrides from the settings API are stored per top-level field under
+// runtimeSettingPrefix in the settings table and applied on top of the values
+// from the environment and config file.
+const (
+	runtimeSettingPrefix = "runtime."
+	maskedSecret         = "********"
+)
+
+var (
+	settingsMu   sync.Mutex
+	baseSettings liveSettings
+)
+
+// settingFields lists the JSON names of all live settings.
+var settingFields = func() []string {
+	var m map[string]json.RawMessage
+	b, _ := json.Marshal(liveSettings{})
+	json.Unmarshal(b, &m)
+	return slices.Sorted(maps.Keys(m))
+}()
+
+// setBaseSettings replaces the settings loaded from the configuration and
+// applies them together with the stored overrides.
+func setBaseSettings(base liveSettings) (liveSettings, error) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	baseSettings = base
+	s, _, err := withOverrides(base)
+	if err != nil {
+		return liveSettings{}, err
+	}
+	applySettings(s)
+	return s, nil
+}
+
+// withOverrides returns base with the stored overrides applied, and the names
+// of the overridden fields.
+func withOverrides(base liveSettings) (liveSettings, []string, error) {
+	s := base
+	s.Trackers = slices.Clone(base.Trackers)
+
+	var overridden []string
+	for _, field := range settingFields {
+		v, ok, err := store.GetSetting(runtimeSettingPrefix + field)
+		if err != nil {
+			return liveSettings{}, nil, err
+		}
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal([]byte(fmt.Sprintf("{%q:%s}", field, v)), &s); err != nil {
+			slog.Warn("Ignoring invalid stored setting", "setting", field, "err", err)
+			continue
+		}
+		overridden = append(overridden, field)
+	}
+	return s, overridden, nil
+}
+
+type settingsResponse struct {
+	Settings   liveSettings `json:"settings"`
+	Overridden []string     `json:"overridden"`
+}
+
+func writeSettings(w http.ResponseWriter) {
+	settingsMu.Lock()
+	_, overridden, err := withOverrides(baseSettings)
+	settingsMu.Unlock()
+	if err != nil {
+		http.Error(w, "Failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	s := settings()
+	if s.ProwlarrApiKey != "" {
+		s.ProwlarrApiKey = maskedSecret
+	}
+	if overridden == nil {
+		overridden = []string{}
+	}
+	writeJSON(w, settingsResponse{Settings: s, Overridden: overridden})
+}
+
+func handleGetSettings(w http.ResponseWriter, r *http.Request) {
+	writeSettings(w)
+}
+
+// handleUpdateSettings applies a partial update, only the fields present in
+// the body are changed and persisted.
+func handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	for field := range patch {
+		if !slices.Contains(settingFields, field) {
+			http.Error(w, "Unknown setting: "+field, http.StatusBadRequest)
+			return
+		}
+	}
+	// The masked key is sent back unchanged by clients that round-trip GET.
+	if string(patch["prowlarrApiKey"]) == fmt.Sprintf("%q", maskedSecret) {
+		delete(patch, "prowlarrApiKey")
+	}
+
+	if err := updateSettings(patch); err != nil {
+		var invalid settingsError
+		if errors.As(err, &invalid) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Failed to save settings", "err", err)
+		http.Error(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Settings updated", "settings", slices.Sorted(maps.Keys(patch)))
+	writeSettings(w)
+}
+
+// settingsError is returned by updateSettings for values that fail
+// validation.
+type settingsError []string
+
+func (e settingsError) Error() string {
+	return strings.Join(e, "\n")
+}
+
+func updateSettings(patch map[string]json.RawMessage) error {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	next := settings()
+	next.Trackers = slices.Clone(next.Trackers)
+	b, _ := json.Marshal(patch)
+	if err := json.Unmarshal(b, &next); err != nil {
+		return settingsError{err.Error()}
+	}
+	if errs := next.validate(); len(errs) > 0 {
+		var msgs settingsError
+		for _, key := range slices.Sorted(maps.Keys(errs)) {
+			msgs = append(msgs, key+": "+errs[key].Error())
+		}
+		return msgs
+	}
+
+	// Persist the full value of each changed field so nested objects like
+	// the quality profile are stored complete.
+	var values map[string]json.RawMessage
+	b, _ = json.Marshal(next)
+	json.Unmarshal(b, &values)
+	for field := range patch {
+		if err := store.SetSetting(runtimeSettingPrefix+field, string(values[field])); err != nil {
+			return err
+		}
+	}
+	applySettings(next)
+	return nil
+}
+
+// handleResetSettings drops all overrides and goes back to the configured
+// values.
+func handleResetSettings(w http.ResponseWriter, r *http.Request) {
+	settingsMu.Lock()
+	for _, field := range settingFields {
+		if err := store.DeleteSetting(runtimeSettingPrefix + field); err != nil {
+			settingsMu.Unlock()
+			http.Error(w, "Failed to reset settings", http.StatusInternalServerError)
+			return
+		}
+	}
+	applySettings(baseSettings)
+	settingsMu.Unlock()
+
+	slog.InfoContext(r.Context(), "Settings reset to configured values")
+	writeSettings(w)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useSettings makes s the current live settings for the duration of the test.
+func useSettings(t *testing.T, s liveSettings) {
+	t.Helper()
+	old := liveConfig.Swap(&s)
+	t.Cleanup(func() { liveConfig.Store(old) })
+}
+
+func TestSettingsOverridesArePersisted(t *testing.T) {
+	setRequiredEnv(t)
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldStore, oldBase := store, baseSettings
+	t.Cleanup(func() { store, baseSettings = oldStore, oldBase })
+	store = openTestStore(t)
+	useSettings(t, c.Live)
+	if _, err := setBaseSettings(c.Live); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleUpdateSettings(rec, httptest.NewRequest(http.MethodPatch, "/api/admin/settings", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := patch(`{"readaheadMB": 100, "quality": {"minSeeders": 20}, "prowlarrApiKey": "********"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp settingsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Settings.ProwlarrApiKey != maskedSecret {
+		t.Errorf("API key not masked: %q", resp.Settings.ProwlarrApiKey)
+	}
+	if got := strings.Join(resp.Overridden, ","); got != "quality,readaheadMB" {
+		t.Errorf("overridden = %s", got)
+	}
+
+	s := settings()
+	if s.ReadaheadMB != 100 || s.Quality.MinSeeders != 20 || s.Quality.PreferredResolution != c.Live.Quality.PreferredResolution {
+		t.Errorf("settings not applied: %+v", s)
+	}
+	if s.ProwlarrApiKey != "prowlarr" {
+		t.Errorf("masked API key overwrote the configured key: %q", s.ProwlarrApiKey)
+	}
+
+	if rec := patch(`{"seedRatio": -1, "torrentTTL": "0s"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid update status = %d", rec.Code)
+	} else if body := rec.Body.String(); !strings.Contains(body, "SEED_RATIO") || !strings.Contains(body, "TORRENT_TTL") {
+		t.Errorf("invalid update body = %q", body)
+	}
+	if rec := patch(`{"port": 1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown setting status = %d", rec.Code)
+	}
+
+	// A restart or config reload keeps the overrides.
+	s, err = setBaseSettings(c.Live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ReadaheadMB != 100 || s.Quality.MinSeeders != 20 || s.SeedRatio != c.Live.SeedRatio {
+		t.Errorf("overrides lost on reload: %+v", s)
+	}
+
+	rec = httptest.NewRecorder()
+	handleResetSettings(rec, httptest.NewRequest(http.MethodDelete, "/api/admin/settings", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reset status = %d", rec.Code)
+	}
+	if s := settings(); s.ReadaheadMB != c.Live.ReadaheadMB || s.Quality != c.Live.Quality {
+		t.Errorf("settings not reset: %+v", s)
+	}
+}
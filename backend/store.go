@@ -0,0 +1,277 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var store Repository
+
+// migrations are applied in order and tracked through SQLite's user_version
+// pragma. Never edit an existing entry, always append a new one.
+var migrations = []string{
+	`CREATE TABLE settings (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	CREATE TABLE torrents (
+		info_hash     TEXT PRIMARY KEY,
+		source        TEXT NOT NULL,
+		metainfo      BLOB,
+		added_at      INTEGER NOT NULL,
+		last_accessed INTEGER NOT NULL
+	);`,
+	`CREATE TABLE users (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		username      TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		role          TEXT NOT NULL,
+		created_at    INTEGER NOT NULL
+	);
+	CREATE TABLE sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		expires_at INTEGER NOT NULL
+	);`,
+	`CREATE TABLE progress (
+		user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		media_type TEXT NOT NULL,
+		tmdb_id    INTEGER NOT NULL,
+		season     INTEGER NOT NULL DEFAULT 0,
+		episode    INTEGER NOT NULL DEFAULT 0,
+		position   REAL NOT NULL,
+		duration   REAL NOT NULL,
+		watched    INTEGER NOT NULL DEFAULT 0,
+		info_hash  TEXT NOT NULL DEFAULT '',
+		file_idx   INTEGER NOT NULL DEFAULT 0,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, media_type, tmdb_id, season, episode)
+	);
+	CREATE INDEX progress_updated ON progress (user_id, updated_at DESC);`,
+	`CREATE TABLE watchlist (
+		user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		list       TEXT NOT NULL,
+		media_type TEXT NOT NULL,
+		tmdb_id    INTEGER NOT NULL,
+		added_at   INTEGER NOT NULL,
+		PRIMARY KEY (user_id, list, media_type, tmdb_id)
+	);`,
+	`CREATE TABLE episode_availability (
+		tmdb_id       INTEGER NOT NULL,
+		season        INTEGER NOT NULL,
+		episode       INTEGER NOT NULL,
+		air_date      TEXT NOT NULL,
+		first_seen_at INTEGER NOT NULL,
+		available_at  INTEGER,
+		release_title TEXT NOT NULL DEFAULT '',
+		resolution    INTEGER NOT NULL DEFAULT 0,
+		seeders       INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (tmdb_id, season, episode)
+	);`,
+	`CREATE TABLE webhook_deliveries (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id    TEXT NOT NULL,
+		event       TEXT NOT NULL,
+		url         TEXT NOT NULL,
+		attempt     INTEGER NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		error       TEXT NOT NULL DEFAULT '',
+		created_at  INTEGER NOT NULL
+	);
+	CREATE INDEX webhook_deliveries_created ON webhook_deliveries (created_at);`,
+	`ALTER TABLE users ADD COLUMN language TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN region TEXT NOT NULL DEFAULT '';`,
+	`CREATE TABLE notify_targets (
+		user_id     INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		webhook_url TEXT NOT NULL DEFAULT '',
+		ntfy_url    TEXT NOT NULL DEFAULT '',
+		email       TEXT NOT NULL DEFAULT ''
+	);`,
+	`CREATE TABLE torrent_first_seen (
+		info_hash  TEXT PRIMARY KEY,
+		first_seen INTEGER NOT NULL
+	);`,
+	// Sources used to be the indexer download links, API key included.
+	`UPDATE torrents SET source = 'magnet:?xt=urn:btih:' || info_hash WHERE source NOT LIKE 'magnet:%';`,
+}
+
+// Repository is the persistence interface the rest of the backend builds on,
+// the global store is only used through it. Store is the SQLite
+// implementation.
+type Repository interface {
+	SettingsRepository
+	TorrentRepository
+	UserRepository
+	ProgressRepository
+	WatchlistRepository
+	AvailabilityRepository
+	WebhookRepository
+	NotifyRepository
+	Close() error
+}
+
+type SettingsRepository interface {
+	GetSetting(key string) (string, bool, error)
+	SetSetting(key, value string) error
+	DeleteSetting(key string) error
+}
+
+type TorrentRepository interface {
+	SaveTorrent(rec torrentRecord) error
+	TouchTorrent(infoHash string, lastAccessed time.Time) error
+	DeleteTorrent(infoHash string) error
+	ListTorrents() ([]torrentRecord, error)
+	TorrentFirstSeen(infoHash string, now time.Time) (time.Time, error)
+}
+
+type torrentRecord struct {
+	InfoHash     string
+	Source       string
+	Metainfo     []byte
+	AddedAt      time.Time
+	LastAccessed time.Time
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+var _ Repository = (*Store)(nil)
+
+func openStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	dsn := "file:" + filepath.Join(dir, "kiroshi.db") +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only supports a single writer, serialising through one
+	// connection avoids SQLITE_BUSY under concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) GetSetting(key string) (string, bool, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (s *Store) SetSetting(key, value string) error {
+	_, err := s.db.Exec(
+		"INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value",
+		key, value,
+	)
+	return err
+}
+
+func (s *Store) DeleteSetting(key string) error {
+	_, err := s.db.Exec("DELETE FROM settings WHERE key = ?", key)
+	return err
+}
+
+func (s *Store) SaveTorrent(rec torrentRecord) error {
+	_, err := s.db.Exec(
+		`INSERT INTO torrents (info_hash, source, metainfo, added_at, last_accessed) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(info_hash) DO UPDATE SET
+			source = excluded.source,
+			metainfo = COALESCE(excluded.metainfo, torrents.metainfo),
+			last_accessed = excluded.last_accessed`,
+		rec.InfoHash, rec.Source, rec.Metainfo, rec.AddedAt.Unix(), rec.LastAccessed.Unix(),
+	)
+	return err
+}
+
+func (s *Store) TouchTorrent(infoHash string, lastAccessed time.Time) error {
+	_, err := s.db.Exec("UPDATE torrents SET last_accessed = ? WHERE info_hash = ?", lastAccessed.Unix(), infoHash)
+	return err
+}
+
+func (s *Store) DeleteTorrent(infoHash string) error {
+	_, err := s.db.Exec("DELETE FROM torrents WHERE info_hash = ?", infoHash)
+	return err
+}
+
+func (s *Store) ListTorrents() ([]torrentRecord, error) {
+	rows, err := s.db.Query("SELECT info_hash, source, metainfo, added_at, last_accessed FROM torrents ORDER BY added_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []torrentRecord
+	for rows.Next() {
+		var rec torrentRecord
+		var addedAt, lastAccessed int64
+		if err := rows.Scan(&rec.InfoHash, &rec.Source, &rec.Metainfo, &addedAt, &lastAccessed); err != nil {
+			return nil, err
+		}
+		rec.AddedAt = time.Unix(addedAt, 0)
+		rec.LastAccessed = time.Unix(lastAccessed, 0)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// TorrentFirstSeen returns when the torrent was first added, recording now if
+// it is new. Unlike the torrents row it is kept after the torrent is dropped.
+func (s *Store) TorrentFirstSeen(infoHash string, now time.Time) (time.Time, error) {
+	if _, err := s.db.Exec("INSERT INTO torrent_first_seen (info_hash, first_seen) VALUES (?, ?) ON CONFLICT(info_hash) DO NOTHING", infoHash, now.Unix()); err != nil {
+		return time.Time{}, err
+	}
+	var firstSeen int64
+	err := s.db.QueryRow("SELECT first_seen FROM torrent_first_seen WHERE info_hash = ?", infoHash).Scan(&firstSeen)
+	return time.Unix(firstSeen, 0), err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := openStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("openStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStoreMigrationsAreIdempotent(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := openStore(dir)
+	if err != nil {
+		t.Fatalf("first open: %v", err)
+	}
+	if err := s.SetSetting("k", "v"); err != nil {
+		t.Fatalf("SetSetting: %v", err)
+	}
+	s.Close()
+
+	s, err = openStore(dir)
+	if err != nil {
+		t.Fatalf("second open: %v", err)
+	}
+	defer s.Close()
+
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Fatalf("user_version = %d, want %d", version, len(migrations))
+	}
+	if v, ok, _ := s.GetSetting("k"); !ok || v != "v" {
+		t.Fatalf("setting not persisted across reopen: %q %v", v, ok)
+	}
+}
+
+func TestStoreDropsIndexerLinksFromTorrentSources(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []torrentRecord{
+		{InfoHash: "aaa", Source: "http://prowlarr:9696/1/download?apikey=secret&link=x"},
+		{InfoHash: "bbb", Source: "magnet:?xt=urn:btih:bbb&dn=Movie"},
+	} {
+		if err := s.SaveTorrent(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Pretend the last migration has not run yet.
+	if _, err := s.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations)-1)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = openStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	recs, err := s.ListTorrents()
+	if err != nil || len(recs) != 2 {
+		t.Fatalf("ListTorrents = %+v, %v", recs, err)
+	}
+	for _, rec := range recs {
+		if want := map[string]string{"aaa": "magnet:?xt=urn:btih:aaa", "bbb": "magnet:?xt=urn:btih:bbb&dn=Movie"}[rec.InfoHash]; rec.Source != want {
+			t.Errorf("%s source = %q, want %q", rec.InfoHash, rec.Source, want)
+		}
+	}
+}
+
+func TestStoreSettings(t *testing.T) {
+	s := openTestStore(t)
+
+	if _, ok, err := s.GetSetting("missing"); err != nil || ok {
+		t.Fatalf("GetSetting(missing) = %v, %v", ok, err)
+	}
+
+	s.SetSetting("limit", "50")
+	s.SetSetting("limit", "75")
+	if v, ok, err := s.GetSetting("limit"); err != nil || !ok || v != "75" {
+		t.Fatalf("GetSetting(limit) = %q, %v, %v", v, ok, err)
+	}
+
+	if err := s.DeleteSetting("limit"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.GetSetting("limit"); ok {
+		t.Fatal("setting still present after delete")
+	}
+}
+
+func TestStoreTorrents(t *testing.T) {
+	s := openTestStore(t)
+
+	added := time.Unix(1700000000, 0)
+	rec := torrentRecord{
+		InfoHash:     "abc",
+		Source:       "magnet:?xt=urn:btih:abc",
+		Metainfo:     []byte("d4:infod4:name1:xee"),
+		AddedAt:      added,
+		LastAccessed: added,
+	}
+	if err := s.SaveTorrent(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving again without metainfo must not wipe the stored copy.
+	rec.Metainfo = nil
+	if err := s.SaveTorrent(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TouchTorrent("abc", added.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := s.ListTorrents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("got %d torrents, want 1", len(list))
+	}
+	got := list[0]
+	if string(got.Metainfo) != "d4:infod4:name1:xee" {
+		t.Errorf("metainfo = %q", got.Metainfo)
+	}
+	if !got.AddedAt.Equal(added) || !got.LastAccessed.Equal(added.Add(time.Hour)) {
+		t.Errorf("times = %v / %v", got.AddedAt, got.LastAccessed)
+	}
+
+	if err := s.DeleteTorrent("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := s.ListTorrents(); len(list) != 0 {
+		t.Fatalf("got %d torrents after delete", len(list))
+	}
+}
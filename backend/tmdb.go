@@ -1,99 +1,490 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	tmdbMaxAttempts = 3
+	tmdbMaxBackoff  = 30 * time.Second
 )
 
-func tmdbGet(path string, w http.ResponseWriter) {
-	u := fmt.Sprintf("https://api.themoviedb.org/3%s", path)
+var (
+	tmdbBaseURL = "https://api.themoviedb.org/3"
+	tmdbCache   = newTmdbResponseCache(tmdbCacheEntries, "")
+	tmdbCalls   singleflight.Group
+	tmdbClient  = newUpstreamClient("tmdb", 10*time.Second)
+	// tmdbRetryDelay is the first backoff after a 429 without Retry-After.
+	tmdbRetryDelay = time.Second
+)
+
+type tmdbResponse struct {
+	body   []byte
+	status int
+}
+
+func tmdbURL(path string) string {
+	u := tmdbBaseURL + path
 	if strings.Contains(u, "?") {
-		u += "&api_key=" + cfg.TmdbApiKey
-	} else {
-		u += "?api_key=" + cfg.TmdbApiKey
+		return u + "&api_key=" + cfg.TmdbApiKey
 	}
+	return u + "?api_key=" + cfg.TmdbApiKey
+}
 
-	resp, err := http.Get(u)
-	if err != nil {
-		http.Error(w, "TMDB request failed", http.StatusBadGateway)
-		return
+// tmdbFetch returns the raw body and status code for a TMDB path. Successful
+// responses are cached for tmdbTTL(path), concurrent requests for the same
+// path share one upstream request. An expired entry is served when TMDB
+// cannot be reached.
+func tmdbFetch(ctx context.Context, path string) ([]byte, int, error) {
+	cached, ok := tmdbCache.Get(path)
+	if ok && cached.fresh() {
+		tmdbCacheLookups.WithLabelValues("hit").Inc()
+		return cached.Body, http.StatusOK, nil
 	}
-	defer resp.Body.Close()
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
+	ch := tmdbCalls.DoChan(path, func() (any, error) {
+		// The result is shared, so one caller going away must not cancel it.
+		return tmdbRequest(context.WithoutCancel(ctx), path)
+	})
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+
+	resp, _ := res.Val.(tmdbResponse)
+	if ok && (res.Err != nil || resp.status == http.StatusTooManyRequests || resp.status >= 500) {
+		slog.WarnContext(ctx, "Serving stale TMDB response", "path", path, "status", resp.status, "err", res.Err)
+		tmdbCacheLookups.WithLabelValues("stale").Inc()
+		return cached.Body, http.StatusOK, nil
+	}
+	tmdbCacheLookups.WithLabelValues("miss").Inc()
+	return resp.body, resp.status, res.Err
 }
 
-func handleSearch(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query().Get("q")
+// tmdbRequest fetches path from TMDB, retrying with backoff when rate
+// limited.
+func tmdbRequest(ctx context.Context, path string) (tmdbResponse, error) {
+	for attempt := 0; ; attempt++ {
+		if err := tmdbWait(ctx); err != nil {
+			return tmdbResponse{}, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, tmdbURL(path), nil)
+		if err != nil {
+			return tmdbResponse{}, err
+		}
+		resp, err := tmdbClient.Do(req)
+		if err != nil {
+			return tmdbResponse{}, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return tmdbResponse{}, err
+		}
+
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				until := time.Unix(reset, 0)
+				if limit := time.Now().Add(tmdbMaxBackoff); until.After(limit) {
+					until = limit
+				}
+				tmdbPauseUntil(until)
+			}
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			delay := tmdbRetryDelay << attempt
+			if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				delay = time.Duration(secs) * time.Second
+			}
+			tmdbPauseUntil(time.Now().Add(min(delay, tmdbMaxBackoff)))
+			if attempt+1 < tmdbMaxAttempts {
+				slog.WarnContext(ctx, "TMDB rate limit hit, backing off", "path", path, "delay", min(delay, tmdbMaxBackoff))
+				continue
+			}
+		}
 
-	type searchResult struct {
-		Movies []any `json:"movies"`
-		Shows  []any `json:"shows"`
+		if resp.StatusCode == http.StatusOK {
+			tmdbCache.Put(tmdbCacheEntry{Path: path, Body: body, Expires: time.Now().Add(tmdbTTL(path))})
+		}
+		return tmdbResponse{body: body, status: resp.StatusCode}, nil
 	}
+}
+
+// tmdbStatusError is returned for non-200 responses.
+type tmdbStatusError struct {
+	Path   string
+	Status int
+}
 
-	movieCh := make(chan []any, 1)
-	showCh := make(chan []any, 1)
+func (e *tmdbStatusError) Error() string {
+	return fmt.Sprintf("TMDB returned %d for %s", e.Status, e.Path)
+}
 
-	go func() {
-		url := fmt.Sprintf("https://api.themoviedb.org/3/search/movie?api_key=%s&query=%s", cfg.TmdbApiKey, q)
-		data := fetchJSON(url)
-		if results, ok := data["results"].([]any); ok {
-			movieCh <- results
+func (e *tmdbStatusError) Is(target error) bool {
+	return target == errMetadataNotFound && e.Status == http.StatusNotFound
+}
+
+func tmdbGetJSON(ctx context.Context, path string, v any) error {
+	body, status, err := tmdbFetch(ctx, path)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return &tmdbStatusError{Path: path, Status: status}
+	}
+	return json.Unmarshal(body, v)
+}
+
+type tmdbGenre struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type tmdbCastMember struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Character   string `json:"character"`
+	ProfilePath string `json:"profile_path"`
+}
+
+type tmdbCredits struct {
+	Cast       []tmdbCastMember `json:"cast"`
+	GuestStars []tmdbCastMember `json:"guest_stars"`
+}
+
+type tmdbVideo struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	Site string `json:"site"`
+	Type string `json:"type"`
+}
+
+type tmdbExternalIds struct {
+	ImdbId string `json:"imdb_id"`
+}
+
+// tmdbSearchResult is a movie or show in search results and lists, movies
+// fill Title and ReleaseDate, shows Name and FirstAirDate. MediaType is only
+// set by endpoints that mix both, like trending.
+type tmdbSearchResult struct {
+	Id            int     `json:"id"`
+	MediaType     string  `json:"media_type"`
+	Title         string  `json:"title"`
+	Name          string  `json:"name"`
+	OriginalTitle string  `json:"original_title"`
+	OriginalName  string  `json:"original_name"`
+	Overview      string  `json:"overview"`
+	ReleaseDate   string  `json:"release_date"`
+	FirstAirDate  string  `json:"first_air_date"`
+	PosterPath    string  `json:"poster_path"`
+	BackdropPath  string  `json:"backdrop_path"`
+	VoteAverage   float64 `json:"vote_average"`
+}
+
+type tmdbMovie struct {
+	Id            int         `json:"id"`
+	ImdbId        string      `json:"imdb_id"`
+	Title         string      `json:"title"`
+	OriginalTitle string      `json:"original_title"`
+	Overview      string      `json:"overview"`
+	Tagline       string      `json:"tagline"`
+	ReleaseDate   string      `json:"release_date"`
+	Runtime       int         `json:"runtime"`
+	Genres        []tmdbGenre `json:"genres"`
+	VoteAverage   float64     `json:"vote_average"`
+	PosterPath    string      `json:"poster_path"`
+	BackdropPath  string      `json:"backdrop_path"`
+	Credits       tmdbCredits `json:"credits"`
+	Videos        struct {
+		Results []tmdbVideo `json:"results"`
+	} `json:"videos"`
+	BelongsToCollection *struct {
+		Id         int    `json:"id"`
+		Name       string `json:"name"`
+		PosterPath string `json:"poster_path"`
+	} `json:"belongs_to_collection"`
+}
+
+type tmdbShow struct {
+	Id           int         `json:"id"`
+	Name         string      `json:"name"`
+	OriginalName string      `json:"original_name"`
+	Overview     string      `json:"overview"`
+	FirstAirDate string      `json:"first_air_date"`
+	LastAirDate  string      `json:"last_air_date"`
+	Status       string      `json:"status"`
+	Genres       []tmdbGenre `json:"genres"`
+	VoteAverage  float64     `json:"vote_average"`
+	PosterPath   string      `json:"poster_path"`
+	BackdropPath string      `json:"backdrop_path"`
+	Seasons      []struct {
+		SeasonNumber int    `json:"season_number"`
+		Name         string `json:"name"`
+		EpisodeCount int    `json:"episode_count"`
+		AirDate      string `json:"air_date"`
+		PosterPath   string `json:"poster_path"`
+	} `json:"seasons"`
+	LastEpisodeToAir *tmdbEpisodeSummary `json:"last_episode_to_air"`
+	NextEpisodeToAir *tmdbEpisodeSummary `json:"next_episode_to_air"`
+	ExternalIds      tmdbExternalIds     `json:"external_ids"`
+	Credits          tmdbCredits         `json:"credits"`
+	Videos           struct {
+		Results []tmdbVideo `json:"results"`
+	} `json:"videos"`
+}
+
+type tmdbSeason struct {
+	SeasonNumber int                  `json:"season_number"`
+	Name         string               `json:"name"`
+	Overview     string               `json:"overview"`
+	AirDate      string               `json:"air_date"`
+	PosterPath   string               `json:"poster_path"`
+	Episodes     []tmdbEpisodeSummary `json:"episodes"`
+}
+
+type tmdbEpisodeSummary struct {
+	Name          string  `json:"name"`
+	Overview      string  `json:"overview"`
+	SeasonNumber  int     `json:"season_number"`
+	EpisodeNumber int     `json:"episode_number"`
+	AirDate       string  `json:"air_date"`
+	StillPath     string  `json:"still_path"`
+	Runtime       int     `json:"runtime"`
+	VoteAverage   float64 `json:"vote_average"`
+}
+
+type tmdbEpisode struct {
+	tmdbEpisodeSummary
+	ExternalIds tmdbExternalIds `json:"external_ids"`
+	Credits     tmdbCredits     `json:"credits"`
+}
+
+// tmdbPersonCredit is a movie or show in a person's combined credits, cast
+// credits set Character, crew credits Job.
+type tmdbPersonCredit struct {
+	tmdbSearchResult
+	Character string `json:"character"`
+	Job       string `json:"job"`
+}
+
+type tmdbPerson struct {
+	Id                 int    `json:"id"`
+	ImdbId             string `json:"imdb_id"`
+	Name               string `json:"name"`
+	Biography          string `json:"biography"`
+	Birthday           string `json:"birthday"`
+	Deathday           string `json:"deathday"`
+	PlaceOfBirth       string `json:"place_of_birth"`
+	KnownForDepartment string `json:"known_for_department"`
+	ProfilePath        string `json:"profile_path"`
+	CombinedCredits    struct {
+		Cast []tmdbPersonCredit `json:"cast"`
+		Crew []tmdbPersonCredit `json:"crew"`
+	} `json:"combined_credits"`
+}
+
+type tmdbCollection struct {
+	Id           int                `json:"id"`
+	Name         string             `json:"name"`
+	Overview     string             `json:"overview"`
+	PosterPath   string             `json:"poster_path"`
+	BackdropPath string             `json:"backdrop_path"`
+	Parts        []tmdbSearchResult `json:"parts"`
+}
+
+// tmdbSearch searches movies (kind "movie") or shows (kind "tv"). year is
+// optional.
+func tmdbSearch(ctx context.Context, kind, query, year string) ([]tmdbSearchResult, error) {
+	q := url.Values{}
+	q.Set("query", query)
+	if year != "" {
+		if kind == "movie" {
+			q.Set("year", year)
 		} else {
-			movieCh <- []any{}
+			q.Set("first_air_date_year", year)
 		}
-	}()
+	}
+	page, err := tmdbGetPage(ctx, "/search/"+kind+"?"+q.Encode())
+	return page.Results, err
+}
 
-	go func() {
-		url := fmt.Sprintf("https://api.themoviedb.org/3/search/tv?api_key=%s&query=%s", cfg.TmdbApiKey, q)
-		data := fetchJSON(url)
-		if results, ok := data["results"].([]any); ok {
-			showCh <- results
-		} else {
-			showCh <- []any{}
+type tmdbPage struct {
+	Page         int                `json:"page"`
+	TotalPages   int                `json:"total_pages"`
+	TotalResults int                `json:"total_results"`
+	Results      []tmdbSearchResult `json:"results"`
+}
+
+// tmdbGetPage fetches one page of a search or list endpoint.
+func tmdbGetPage(ctx context.Context, path string) (tmdbPage, error) {
+	return tmdbGetLocalized(ctx, path, func(v *tmdbPage, en func() tmdbPage) {
+		for i := range v.Results {
+			r := &v.Results[i]
+			if r.Overview != "" {
+				continue
+			}
+			for _, e := range en().Results {
+				if e.Id == r.Id {
+					r.Overview = e.Overview
+				}
+			}
 		}
-	}()
+	})
+}
+
+type tmdbGenreList struct {
+	Genres []tmdbGenre `json:"genres"`
+}
 
-	movies := <-movieCh
-	shows := <-showCh
+func tmdbGenres(ctx context.Context, kind string) ([]tmdbGenre, error) {
+	list, err := tmdbGetLocalized(ctx, "/genre/"+kind+"/list", func(v *tmdbGenreList, en func() tmdbGenreList) {
+		for i := range v.Genres {
+			if v.Genres[i].Name != "" {
+				continue
+			}
+			for _, e := range en().Genres {
+				if e.Id == v.Genres[i].Id {
+					v.Genres[i].Name = e.Name
+				}
+			}
+		}
+	})
+	return list.Genres, err
+}
+
+// The detail fetchers always request the same appended responses so every
+// caller shares one cache entry per title and locale. Titles without a
+// translation fall back to English.
+
+func tmdbMovieDetails(ctx context.Context, id int) (tmdbMovie, error) {
+	path := fmt.Sprintf("/movie/%d?append_to_response=credits,videos&include_video_language=%s", id, videoLanguages(ctx))
+	return tmdbGetLocalized(ctx, path, func(m *tmdbMovie, en func() tmdbMovie) {
+		fallback(&m.Title, func() string { return en().Title })
+		fallback(&m.Overview, func() string { return en().Overview })
+		fallback(&m.Tagline, func() string { return en().Tagline })
+	})
+}
+
+func tmdbShowDetails(ctx context.Context, id int) (tmdbShow, error) {
+	path := fmt.Sprintf("/tv/%d?append_to_response=credits,videos,external_ids&include_video_language=%s", id, videoLanguages(ctx))
+	return tmdbGetLocalized(ctx, path, func(s *tmdbShow, en func() tmdbShow) {
+		fallback(&s.Name, func() string { return en().Name })
+		fallback(&s.Overview, func() string { return en().Overview })
+	})
+}
+
+func tmdbSeasonDetails(ctx context.Context, id, season int) (tmdbSeason, error) {
+	return tmdbGetLocalized(ctx, fmt.Sprintf("/tv/%d/season/%d", id, season), func(s *tmdbSeason, en func() tmdbSeason) {
+		fallback(&s.Overview, func() string { return en().Overview })
+		for i := range s.Episodes {
+			ep := &s.Episodes[i]
+			if ep.Name != "" && ep.Overview != "" {
+				continue
+			}
+			for _, e := range en().Episodes {
+				if e.EpisodeNumber == ep.EpisodeNumber {
+					fallback(&ep.Name, func() string { return e.Name })
+					fallback(&ep.Overview, func() string { return e.Overview })
+				}
+			}
+		}
+	})
+}
 
-	writeJSON(w, map[string]any{
-		"movies": movies,
-		"shows":  shows,
+func tmdbEpisodeDetails(ctx context.Context, id, season, episode int) (tmdbEpisode, error) {
+	path := fmt.Sprintf("/tv/%d/season/%d/episode/%d?append_to_response=credits,external_ids", id, season, episode)
+	return tmdbGetLocalized(ctx, path, func(e *tmdbEpisode, en func() tmdbEpisode) {
+		fallback(&e.Name, func() string { return en().Name })
+		fallback(&e.Overview, func() string { return en().Overview })
 	})
 }
 
-func handleMovie(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	tmdbGet(fmt.Sprintf("/movie/%s?append_to_response=credits,videos,images", id), w)
+func tmdbPersonDetails(ctx context.Context, id int) (tmdbPerson, error) {
+	return tmdbGetLocalized(ctx, fmt.Sprintf("/person/%d?append_to_response=combined_credits", id), func(p *tmdbPerson, en func() tmdbPerson) {
+		fallback(&p.Biography, func() string { return en().Biography })
+	})
 }
 
-func handleShow(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	tmdbGet(fmt.Sprintf("/tv/%s?append_to_response=credits,videos,images,external_ids", id), w)
+func tmdbCollectionDetails(ctx context.Context, id int) (tmdbCollection, error) {
+	return tmdbGetLocalized(ctx, fmt.Sprintf("/collection/%d", id), func(c *tmdbCollection, en func() tmdbCollection) {
+		fallback(&c.Name, func() string { return en().Name })
+		fallback(&c.Overview, func() string { return en().Overview })
+	})
 }
 
-func handleSeason(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	season := r.URL.Query().Get("season")
-	if id == "" || season == "" {
-		http.Error(w, "Missing required parameters", http.StatusBadRequest)
-		return
+// videoLanguages lists trailers in the user's language and in English.
+func videoLanguages(ctx context.Context) string {
+	lang, _, _ := strings.Cut(requestLocale(ctx).Language, "-")
+	if lang == "" || lang == "en" {
+		return "en"
 	}
-	tmdbGet(fmt.Sprintf("/tv/%s/season/%s", id, season), w)
+	return lang + ",en"
 }
 
-func handleEpisode(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	season := r.URL.Query().Get("season")
-	episode := r.URL.Query().Get("episode")
-	if id == "" || season == "" || episode == "" {
-		http.Error(w, "Missing required parameters", http.StatusBadRequest)
-		return
+// tmdbProvider is the MetadataProvider backed by the TMDB API.
+type tmdbProvider struct{}
+
+func (tmdbProvider) Name() string {
+	return "tmdb"
+}
+
+func (tmdbProvider) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tmdbURL("/configuration"), nil)
+	if err != nil {
+		return err
 	}
-	tmdbGet(fmt.Sprintf("/tv/%s/season/%s/episode/%s?append_to_response=credits,videos,images,external_ids", id, season, episode), w)
+	return checkResponse(tmdbClient.Do(req))
+}
+
+func (tmdbProvider) Search(ctx context.Context, kind, query, year string) ([]tmdbSearchResult, error) {
+	return tmdbSearch(ctx, kind, query, year)
+}
+
+func (tmdbProvider) Movie(ctx context.Context, id int) (tmdbMovie, error) {
+	return tmdbMovieDetails(ctx, id)
+}
+
+func (tmdbProvider) Show(ctx context.Context, id int) (tmdbShow, error) {
+	return tmdbShowDetails(ctx, id)
+}
+
+func (tmdbProvider) Season(ctx context.Context, showId, season int) (tmdbSeason, error) {
+	return tmdbSeasonDetails(ctx, showId, season)
+}
+
+func (tmdbProvider) Episode(ctx context.Context, showId, season, episode int) (tmdbEpisode, error) {
+	return tmdbEpisodeDetails(ctx, showId, season, episode)
+}
+
+func (tmdbProvider) Person(ctx context.Context, id int) (tmdbPerson, error) {
+	return tmdbPersonDetails(ctx, id)
+}
+
+func (tmdbProvider) Collection(ctx context.Context, id int) (tmdbCollection, error) {
+	return tmdbCollectionDetails(ctx, id)
+}
+
+func (tmdbProvider) List(ctx context.Context, list string, page int) (tmdbPage, error) {
+	return tmdbGetPage(ctx, fmt.Sprintf("/%s?page=%d", list, page))
+}
+
+func (tmdbProvider) Genres(ctx context.Context, kind string) ([]tmdbGenre, error) {
+	return tmdbGenres(ctx, kind)
+}
+
+func (tmdbProvider) ByGenre(ctx context.Context, kind string, genre, page int) (tmdbPage, error) {
+	return tmdbGetPage(ctx, fmt.Sprintf("/discover/%s?with_genres=%d&sort_by=popularity.desc&page=%d", kind, genre, page))
 }
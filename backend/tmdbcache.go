@@ -0,0 +1,318 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tmdbCacheEntries = 2000
+	// tmdbDiskCacheBytes bounds the disk cache, the least recently used
+	// entries are removed when it grows past it.
+	tmdbDiskCacheBytes = 256 * 1024 * 1024
+	// Expired entries are kept this long so they can still be served when
+	// TMDB is down.
+	tmdbStaleTTL           = 7 * 24 * time.Hour
+	tmdbCachePruneInterval = 6 * time.Hour
+)
+
+// tmdbTTLs maps TMDB paths to how long their responses are cached. The first
+// match wins, paths that match nothing use tmdbDefaultTTL.
+var tmdbTTLs = []struct {
+	pattern *regexp.Regexp
+	ttl     time.Duration
+}{
+	{regexp.MustCompile(`^/trending/`), 30 * time.Minute},
+	{regexp.MustCompile(`^/(movie|tv)/(popular|top_rated|now_playing|upcoming|airing_today|on_the_air)\b`), 3 * time.Hour},
+	{regexp.MustCompile(`^/discover/`), 3 * time.Hour},
+	{regexp.MustCompile(`^/search/`), 6 * time.Hour},
+	// Running shows get new episodes and air dates.
+	{regexp.MustCompile(`^/tv/\d+`), 6 * time.Hour},
+	{regexp.MustCompile(`^/(movie|collection|person)/\d+`), 7 * 24 * time.Hour},
+	{regexp.MustCompile(`^/(configuration|genre)\b`), 7 * 24 * time.Hour},
+}
+
+const tmdbDefaultTTL = time.Hour
+
+// tmdbDiskCached reports whether responses for path are written to disk.
+// Search queries are typed by users, rarely repeat and would fill the disk
+// cache with one-off entries, so they are kept in memory only.
+func tmdbDiskCached(path string) bool {
+	return !strings.HasPrefix(path, "/search/")
+}
+
+func tmdbTTL(path string) time.Duration {
+	for _, t := range tmdbTTLs {
+		if t.pattern.MatchString(path) {
+			return t.ttl
+		}
+	}
+	return tmdbDefaultTTL
+}
+
+type tmdbCacheEntry struct {
+	Path    string          `json:"path"`
+	Body    json.RawMessage `json:"body"`
+	Expires time.Time       `json:"expires"`
+}
+
+func (e tmdbCacheEntry) fresh() bool {
+	return time.Now().Before(e.Expires)
+}
+
+// tmdbResponseCache keeps the most recently used TMDB responses in memory and
+// up to maxDisk bytes of them on disk, so a restart does not start with a
+// cold cache. File modification times record the last use of disk entries.
+type tmdbResponseCache struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List
+	items map[string]*list.Element
+	// dir is empty when the disk cache is disabled.
+	dir     string
+	maxDisk int64
+	// diskBytes estimates the disk cache size between prunes.
+	diskBytes int64
+	pruning   bool
+}
+
+func newTmdbResponseCache(max int, dir string) *tmdbResponseCache {
+	return &tmdbResponseCache{max: max, order: list.New(), items: map[string]*list.Element{}, dir: dir, maxDisk: tmdbDiskCacheBytes}
+}
+
+// Get returns the cached response for path, which may be expired.
+func (c *tmdbResponseCache) Get(path string) (tmdbCacheEntry, bool) {
+	c.mu.Lock()
+	if el, ok := c.items[path]; ok {
+		c.order.MoveToFront(el)
+		e := el.Value.(tmdbCacheEntry)
+		c.mu.Unlock()
+		return e, true
+	}
+	dir := c.dir
+	c.mu.Unlock()
+
+	if dir == "" || !tmdbDiskCached(path) {
+		return tmdbCacheEntry{}, false
+	}
+	name := tmdbCacheFile(dir, path)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return tmdbCacheEntry{}, false
+	}
+	var e tmdbCacheEntry
+	if err := json.Unmarshal(data, &e); err != nil || e.Path != path {
+		return tmdbCacheEntry{}, false
+	}
+	now := time.Now()
+	os.Chtimes(name, now, now)
+	c.add(e)
+	return e, true
+}
+
+func (c *tmdbResponseCache) Put(e tmdbCacheEntry) {
+	c.add(e)
+
+	c.mu.Lock()
+	dir := c.dir
+	c.mu.Unlock()
+	if dir == "" || !tmdbDiskCached(e.Path) {
+		return
+	}
+	n, err := writeTmdbCacheFile(dir, e)
+	if err != nil {
+		slog.Warn("Failed to write TMDB cache", "err", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Overwritten entries are counted twice, the next prune corrects that.
+	c.diskBytes += n
+	if c.diskBytes > c.maxDisk && !c.pruning {
+		c.pruning = true
+		go c.prune()
+	}
+}
+
+func (c *tmdbResponseCache) add(e tmdbCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[e.Path]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[e.Path] = c.order.PushFront(e)
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(tmdbCacheEntry).Path)
+	}
+}
+
+// Clear drops the in-memory entries, the disk cache is left alone.
+func (c *tmdbResponseCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.items)
+}
+
+func (c *tmdbResponseCache) setDir(dir string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dir = dir
+}
+
+func tmdbCacheFile(dir, path string) string {
+	sum := sha256.Sum256([]byte(path))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(dir, name[:2], name+".json")
+}
+
+// writeTmdbCacheFile stores e and returns the size of the written file.
+func writeTmdbCacheFile(dir string, e tmdbCacheEntry) (int64, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	name := tmdbCacheFile(dir, e.Path)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return 0, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	return int64(len(data)), os.Rename(f.Name(), name)
+}
+
+// prune removes disk entries that expired more than tmdbStaleTTL ago, then
+// the least recently used ones until the disk cache is at 90% of maxDisk.
+func (c *tmdbResponseCache) prune() {
+	c.mu.Lock()
+	dir, limit := c.dir, c.maxDisk
+	c.mu.Unlock()
+
+	type cacheFile struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cacheFile
+	var used int64
+	removed := 0
+	filepath.WalkDir(dir, func(name string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(name) != ".json" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil
+		}
+		var e tmdbCacheEntry
+		if json.Unmarshal(data, &e) != nil || time.Since(e.Expires) > tmdbStaleTTL || !tmdbDiskCached(e.Path) {
+			if os.Remove(name) == nil {
+				removed++
+			}
+			return nil
+		}
+		files = append(files, cacheFile{name, info.Size(), info.ModTime()})
+		used += info.Size()
+		return nil
+	})
+
+	if used > limit {
+		slices.SortFunc(files, func(a, b cacheFile) int { return a.modTime.Compare(b.modTime) })
+		target := limit / 10 * 9
+		for _, f := range files {
+			if used <= target {
+				break
+			}
+			if os.Remove(f.name) == nil {
+				used -= f.size
+				removed++
+			}
+		}
+	}
+	if removed > 0 {
+		slog.Debug("Pruned TMDB cache", "removed", removed, "used_bytes", used)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.diskBytes = used
+	c.pruning = false
+}
+
+func initTmdbCache() {
+	dir := filepath.Join(cfg.DataDir, "tmdb-cache")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		slog.Warn("TMDB disk cache disabled", "err", err)
+		return
+	}
+	tmdbCache.setDir(dir)
+	go func() {
+		for {
+			tmdbCache.prune()
+			time.Sleep(tmdbCachePruneInterval)
+		}
+	}()
+}
+
+// tmdbLimiter pauses all TMDB requests after a 429 or when the rate limit
+// headers say the quota is used up.
+var tmdbLimiter struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+func tmdbPauseUntil(t time.Time) {
+	tmdbLimiter.mu.Lock()
+	defer tmdbLimiter.mu.Unlock()
+	if t.After(tmdbLimiter.until) {
+		tmdbLimiter.until = t
+	}
+}
+
+func tmdbWait(ctx context.Context) error {
+	tmdbLimiter.mu.Lock()
+	d := time.Until(tmdbLimiter.until)
+	tmdbLimiter.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
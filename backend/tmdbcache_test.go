@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func useTmdbServer(t *testing.T, h http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+
+	oldBase, oldCache, oldDelay := tmdbBaseURL, tmdbCache, tmdbRetryDelay
+	t.Cleanup(func() {
+		tmdbBaseURL, tmdbCache, tmdbRetryDelay = oldBase, oldCache, oldDelay
+		tmdbLimiter.until = time.Time{}
+	})
+	tmdbBaseURL = server.URL
+	tmdbCache = newTmdbResponseCache(10, t.TempDir())
+	tmdbRetryDelay = 10 * time.Millisecond
+}
+
+func TestTmdbFetchCoalescesConcurrentRequests(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	useTmdbServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Write([]byte(`{"id": 1}`))
+	})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, status, err := tmdbFetch(context.Background(), "/movie/1")
+			if err != nil || status != http.StatusOK || string(body) != `{"id": 1}` {
+				t.Errorf("tmdbFetch = %q, %d, %v", body, status, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if _, _, err := tmdbFetch(context.Background(), "/movie/1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("TMDB called %d times, want 1", n)
+	}
+}
+
+func TestTmdbCacheSurvivesRestart(t *testing.T) {
+	var calls atomic.Int32
+	useTmdbServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"id":2}`))
+	})
+
+	if _, _, err := tmdbFetch(context.Background(), "/movie/2"); err != nil {
+		t.Fatal(err)
+	}
+	tmdbCache = newTmdbResponseCache(10, tmdbCache.dir)
+	body, _, err := tmdbFetch(context.Background(), "/movie/2")
+	if err != nil || string(body) != `{"id":2}` {
+		t.Fatalf("tmdbFetch = %q, %v", body, err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("TMDB called %d times, want 1", n)
+	}
+}
+
+func TestTmdbFetchServesStaleOnError(t *testing.T) {
+	var fail atomic.Bool
+	useTmdbServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"page": 1}`))
+	})
+
+	tmdbFetch(context.Background(), "/trending/movie/week")
+	e, _ := tmdbCache.Get("/trending/movie/week")
+	e.Expires = time.Now().Add(-time.Minute)
+	tmdbCache.add(e)
+
+	fail.Store(true)
+	body, status, err := tmdbFetch(context.Background(), "/trending/movie/week")
+	if err != nil || status != http.StatusOK || string(body) != `{"page": 1}` {
+		t.Errorf("tmdbFetch = %q, %d, %v", body, status, err)
+	}
+}
+
+func TestTmdbFetchBacksOffWhenRateLimited(t *testing.T) {
+	var calls atomic.Int32
+	useTmdbServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+
+	start := time.Now()
+	_, status, err := tmdbFetch(context.Background(), "/movie/3")
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("tmdbFetch = %d, %v", status, err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("TMDB called %d times, want 3", n)
+	}
+	// 10ms after the first 429, 20ms after the second.
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("retried after %s, want backoff", elapsed)
+	}
+}
+
+func TestTmdbTTL(t *testing.T) {
+	tests := map[string]time.Duration{
+		"/trending/all/day":              30 * time.Minute,
+		"/movie/popular?page=2":          3 * time.Hour,
+		"/movie/550?append_to_response=": 7 * 24 * time.Hour,
+		"/tv/1399/season/1":              6 * time.Hour,
+		"/search/movie?query=alien":      6 * time.Hour,
+		"/tv/1399/external_ids":          6 * time.Hour,
+		"/find/tt0137523":                tmdbDefaultTTL,
+	}
+	for path, want := range tests {
+		if got := tmdbTTL(path); got != want {
+			t.Errorf("tmdbTTL(%q) = %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestTmdbDiskCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	useTmdbServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	c := tmdbCache
+
+	expires := time.Now().Add(time.Hour)
+	var size int64
+	for i := range 4 {
+		path := fmt.Sprintf("/movie/%d", i)
+		c.Put(tmdbCacheEntry{Path: path, Body: json.RawMessage(`{"id": 0}`), Expires: expires})
+		// Entry 0 was used last, 1 is the oldest.
+		used := time.Now().Add(time.Duration(i) * time.Minute)
+		if i == 0 {
+			used = used.Add(time.Hour)
+		}
+		os.Chtimes(tmdbCacheFile(c.dir, path), used, used)
+		info, err := os.Stat(tmdbCacheFile(c.dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size = info.Size()
+	}
+
+	c.Put(tmdbCacheEntry{Path: "/search/movie?query=matrix", Body: json.RawMessage(`{}`), Expires: expires})
+	if _, err := os.Stat(tmdbCacheFile(c.dir, "/search/movie?query=matrix")); !os.IsNotExist(err) {
+		t.Errorf("search response written to disk: %v", err)
+	}
+	if _, ok := c.Get("/search/movie?query=matrix"); !ok {
+		t.Error("search response not cached in memory")
+	}
+
+	c.mu.Lock()
+	c.maxDisk = 3 * size
+	c.mu.Unlock()
+	c.prune()
+
+	for i, want := range []bool{true, false, false, true} {
+		_, err := os.Stat(tmdbCacheFile(c.dir, fmt.Sprintf("/movie/%d", i)))
+		if kept := err == nil; kept != want {
+			t.Errorf("/movie/%d kept = %v, want %v", i, kept, want)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.diskBytes != 2*size {
+		t.Errorf("diskBytes = %d, want %d", c.diskBytes, 2*size)
+	}
+}
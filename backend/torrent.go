@@ -7,12 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -24,36 +26,59 @@ import (
 )
 
 const (
-	cleanupInterval      = 5 * time.Minute
-	torrentTTL           = 15 * time.Minute
-	maxRatio             = 2.0
-	torrentClientTimeout = 60 * time.Second
+	cleanupInterval       = 5 * time.Minute
+	torrentClientTimeout  = 60 * time.Second
+	accessRefreshInterval = 30 * time.Second
 )
 
+// Reasons passed to dropTorrent, reported in the torrent.dropped event.
+const (
+	dropInactive        = "inactive"
+	dropRatio           = "ratio"
+	dropStorage         = "storage"
+	dropDeleted         = "deleted"
+	dropMetadataTimeout = "metadata_timeout"
+	dropNoVideo         = "no_video"
+)
+
+var videoExts = map[string]bool{".mkv": true, ".mp4": true, ".avi": true, ".mov": true, ".wmv": true, ".flv": true, ".webm": true}
+
 var (
 	tClient      *torrent.Client
 	lastAccessed sync.Map
+	addedAt      sync.Map
+	firstSeen    sync.Map
+	// pinnedUntil protects torrents from the inactivity TTL until the given
+	// time, e.g. episodes that were pre-cached for the evening.
+	pinnedUntil sync.Map
+
+	activeStreamsMu sync.Mutex
+	activeStreams   = map[string]int{}
 )
 
 type addTorrentRequest struct {
-	Guid    string `json:"guid"`
-	Link    string `json:"link"`
-	Season  int    `json:"season,omitempty"`
-	Episode int    `json:"episode,omitempty"`
+	Guid     string `json:"guid"`
+	Link     string `json:"link"`
+	InfoHash string `json:"infoHash,omitempty"`
+	FileIdx  *int   `json:"fileIdx,omitempty"`
+	Season   int    `json:"season,omitempty"`
+	Episode  int    `json:"episode,omitempty"`
 }
 
 type streamResponse struct {
 	StreamUrl string `json:"streamUrl"`
 	FileName  string `json:"fileName"`
+	InfoHash  string `json:"infoHash,omitempty"`
+	FileIdx   int    `json:"fileIdx"`
 }
 
 func initTorrentClient() {
-	log.Println("Initializing torrent client...")
+	slog.Info("Initializing torrent client")
 	c := torrent.NewDefaultClientConfig()
 	c.DataDir = cfg.DownloadDir
 	c.NoDefaultPortForwarding = true
 	c.ListenPort = cfg.TorrentPort
-	c.EstablishedConnsPerTorrent = 500
+	c.EstablishedConnsPerTorrent = settings().MaxConnsPerTorrent
 	c.TorrentPeersHighWater = 1000
 	c.TorrentPeersLowWater = 100
 	c.HandshakesTimeout = 4 * time.Second
@@ -65,15 +90,117 @@ func initTorrentClient() {
 	var err error
 	tClient, err = torrent.NewClient(c)
 	if err != nil {
-		log.Fatalf("Failed to create torrent client: %v", err)
+		fatal("Failed to create torrent client", "err", err)
 	}
-	log.Printf("Torrent client started on port %d", cfg.TorrentPort)
+	slog.Info("Torrent client started", "port", cfg.TorrentPort)
 
+	restoreTorrents()
 	go cleanupRoutine()
 }
 
+func restoreTorrents() {
+	records, err := store.ListTorrents()
+	if err != nil {
+		slog.Error("Failed to load saved torrents", "err", err)
+		return
+	}
+
+	for _, rec := range records {
+		var t *torrent.Torrent
+		if len(rec.Metainfo) > 0 {
+			var mi *metainfo.MetaInfo
+			mi, err = metainfo.Load(bytes.NewReader(rec.Metainfo))
+			if err == nil {
+				t, err = tClient.AddTorrent(mi)
+			}
+		} else if strings.HasPrefix(rec.Source, "magnet:") {
+			t, err = tClient.AddMagnet(rec.Source)
+		} else {
+			err = errors.New("no metainfo saved")
+		}
+		if err != nil {
+			slog.Warn("Failed to restore torrent", "infohash", rec.InfoHash, "err", err)
+			store.DeleteTorrent(rec.InfoHash)
+			continue
+		}
+
+		t.AddTrackers(trackerTiers(settings().Trackers))
+		lastAccessed.Store(rec.InfoHash, rec.LastAccessed)
+		addedAt.Store(rec.InfoHash, rec.AddedAt)
+		go watchTorrent(t, false)
+	}
+	slog.Info("Restored torrents", "count", len(tClient.Torrents()))
+}
+
+// saveTorrent persists a torrent so it is restored after a restart. The
+// source is recorded as a bare magnet, indexer download links carry the
+// indexer's API key.
+func saveTorrent(t *torrent.Torrent) {
+	var buf bytes.Buffer
+	mi := t.Metainfo()
+	if err := mi.Write(&buf); err != nil {
+		slog.Warn("Failed to encode metainfo", "infohash", t.InfoHash().String(), "err", err)
+	}
+
+	ih := t.InfoHash().String()
+	added, _ := addedAt.LoadOrStore(ih, time.Now())
+	err := store.SaveTorrent(torrentRecord{
+		InfoHash:     ih,
+		Source:       "magnet:?xt=urn:btih:" + t.InfoHash().HexString(),
+		Metainfo:     buf.Bytes(),
+		AddedAt:      added.(time.Time),
+		LastAccessed: time.Now(),
+	})
+	if err != nil {
+		slog.Error("Failed to save torrent", "infohash", ih, "err", err)
+	}
+}
+
+func dropTorrent(t *torrent.Torrent, reason string) {
+	ih := t.InfoHash().String()
+	emitEvent(eventTorrentDropped, torrentEvent(t, reason))
+	torrentDrops.WithLabelValues(reason).Inc()
+	t.Drop()
+	lastAccessed.Delete(ih)
+	addedAt.Delete(ih)
+	pinnedUntil.Delete(ih)
+	if err := store.DeleteTorrent(ih); err != nil {
+		slog.Error("Failed to delete torrent from store", "infohash", ih, "err", err)
+	}
+}
+
+// trackNewTorrent records when a torrent was first added and starts watching
+// it for webhook events. Torrents that are already known are left alone.
+func trackNewTorrent(t *torrent.Torrent) {
+	if _, loaded := addedAt.LoadOrStore(t.InfoHash().String(), time.Now()); loaded {
+		return
+	}
+	t.SetMaxEstablishedConns(settings().MaxConnsPerTorrent)
+	emitEvent(eventTorrentAdded, torrentEvent(t, ""))
+	go watchTorrent(t, true)
+}
+
+func updateTorrentSettings(old, s liveSettings) {
+	if tClient == nil {
+		return
+	}
+	trackersChanged := !slices.Equal(old.Trackers, s.Trackers)
+	for _, t := range tClient.Torrents() {
+		if old.MaxConnsPerTorrent != s.MaxConnsPerTorrent {
+			t.SetMaxEstablishedConns(s.MaxConnsPerTorrent)
+		}
+		if trackersChanged {
+			// Trackers from the magnet or .torrent file stay, only the
+			// previously configured ones are dropped.
+			t.ModifyTrackers(withoutTrackers(t.Metainfo().AnnounceList, old.Trackers))
+			t.AddTrackers(trackerTiers(s.Trackers))
+		}
+	}
+}
+
 func handleAddTorrent(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
+	ctx := r.Context()
 
 	var req addTorrentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -81,17 +208,55 @@ func handleAddTorrent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("[torrent] Add request: guid: %s, link: %s (S:%d E:%d)", req.Guid, req.Link, req.Season, req.Episode)
+	slog.InfoContext(ctx, "Add torrent request", "guid", req.Guid, "link", req.Link, "season", req.Season, "episode", req.Episode)
+
+	if id, ok := strings.CutPrefix(req.Link, librarySourcePrefix); ok {
+		entry, found := libraryEntryById(id)
+		if !found {
+			http.Error(w, "Library file not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, streamResponse{
+			StreamUrl: "/api/library/stream/" + entry.Id,
+			FileName:  filepath.Base(entry.Path),
+		})
+		return
+	}
 
 	var t *torrent.Torrent
 	var err error
 
-	for _, source := range []string{req.Guid, req.Link} {
-		t, err = resolveAndAdd(source)
-		if err == nil {
-			break
+	sources := []string{req.Guid, req.Link}
+	if req.InfoHash != "" {
+		var ih metainfo.Hash
+		if err := ih.FromHexString(req.InfoHash); err != nil {
+			http.Error(w, "Invalid infoHash", http.StatusBadRequest)
+			return
+		}
+		// Resuming a previous playback, the torrent is usually still in the
+		// client or restored from the store. A bare hash of an unknown torrent
+		// would wait for peers, so it is only tried when there is no guid or
+		// link, e.g. after the torrent was cleaned up.
+		magnet := "magnet:?xt=urn:btih:" + ih.HexString()
+		if existing, ok := tClient.Torrent(ih); ok {
+			t = existing
+		} else if req.Guid == "" && req.Link == "" {
+			sources = []string{magnet}
+		}
+	}
+
+	if t == nil {
+		err = errors.New("no source given")
+		for _, source := range sources {
+			if source == "" {
+				continue
+			}
+			t, err = resolveAndAdd(ctx, source)
+			if err == nil {
+				break
+			}
+			slog.WarnContext(ctx, "Failed to add torrent", "source", source, "err", err)
 		}
-		log.Printf("[torrent] Failed to add %s: %v", source, err)
 	}
 
 	if err != nil {
@@ -99,23 +264,32 @@ func handleAddTorrent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t.AddTrackers(DefaultTrackers)
+	t.AddTrackers(trackerTiers(settings().Trackers))
 
 	ih := t.InfoHash().HexString()
+	trackNewTorrent(t)
 
-	ctx, cancel := context.WithTimeout(r.Context(), torrentClientTimeout)
+	ctx, cancel := context.WithTimeout(ctx, torrentClientTimeout)
 	defer cancel()
 
+	waitStart := time.Now()
 	select {
 	case <-t.GotInfo():
-		log.Printf("[torrent] Metadata received for %s (%s)", t.Name(), ih)
+		metadataDuration.Observe(time.Since(waitStart).Seconds())
+		slog.InfoContext(ctx, "Metadata received", "name", t.Name(), "infohash", ih)
+		saveTorrent(t)
 	case <-ctx.Done():
-		t.Drop()
+		// Timeouts are the slowest waits, leaving them out would hide them.
+		metadataDuration.Observe(time.Since(waitStart).Seconds())
+		dropTorrent(t, dropMetadataTimeout)
 		http.Error(w, "Timeout waiting for torrent metadata", http.StatusGatewayTimeout)
 		return
 	}
 
 	fileIdx, file := selectFile(t, req.Season, req.Episode)
+	if files := t.Files(); req.FileIdx != nil && *req.FileIdx >= 0 && *req.FileIdx < len(files) {
+		fileIdx, file = *req.FileIdx, files[*req.FileIdx]
+	}
 	if file == nil {
 		http.Error(w, "No suitable video file found", http.StatusNotFound)
 		return
@@ -128,19 +302,54 @@ func handleAddTorrent(w http.ResponseWriter, r *http.Request) {
 	resp := streamResponse{
 		StreamUrl: fmt.Sprintf("/api/stream/%s/%d", ih, fileIdx),
 		FileName:  file.DisplayPath(),
+		InfoHash:  ih,
+		FileIdx:   fileIdx,
 	}
 
-	log.Printf("[torrent] Ready in %s: %s", time.Since(start), file.DisplayPath())
+	slog.InfoContext(ctx, "Torrent ready", "file", file.DisplayPath(), "duration", time.Since(start))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
 func handleStream(w http.ResponseWriter, r *http.Request) {
-	hashStr := r.PathValue("hash")
-	fileIdxStr := r.PathValue("fileIdx")
+	start := time.Now()
+	t, idx, file, ok := lookupFile(w, r)
+	if !ok {
+		return
+	}
+	// Only streams of a resolved file count towards the first byte latency,
+	// not fast 400 and 404 errors.
+	w = &firstByteWriter{ResponseWriter: w, start: start}
+	slog.InfoContext(r.Context(), "Streaming file", "file", file.DisplayPath())
 
+	reader := newStreamReader(t.InfoHash().String(), file.NewReader())
+	reader.SetResponsive()
+	reader.SetReadahead(int64(settings().ReadaheadMB) * 1024 * 1024)
+	defer reader.Close()
+
+	setValidators(w, t, idx)
+	http.ServeContent(w, r, file.DisplayPath(), torrentModTime(t), reader)
+}
+
+func handleDownload(w http.ResponseWriter, r *http.Request) {
+	t, idx, file, ok := lookupFile(w, r)
+	if !ok {
+		return
+	}
+	slog.InfoContext(r.Context(), "Downloading file", "file", file.DisplayPath())
+
+	reader := newStreamReader(t.InfoHash().String(), file.NewReader())
+	defer reader.Close()
+
+	name := path.Base(file.DisplayPath())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	setValidators(w, t, idx)
+	http.ServeContent(w, r, name, torrentModTime(t), reader)
+}
+
+func handleDeleteTorrent(w http.ResponseWriter, r *http.Request) {
 	var ih metainfo.Hash
-	if err := ih.FromHexString(hashStr); err != nil {
+	if err := ih.FromHexString(r.PathValue("hash")); err != nil {
 		http.Error(w, "Invalid infohash", http.StatusBadRequest)
 		return
 	}
@@ -150,33 +359,72 @@ func handleStream(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Torrent not found", http.StatusNotFound)
 		return
 	}
+	if hasActiveStreams(ih.String()) {
+		http.Error(w, "Torrent is currently streaming", http.StatusConflict)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Deleting torrent", "name", t.Name(), "infohash", ih.String())
+	dropTorrent(t, dropDeleted)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func lookupFile(w http.ResponseWriter, r *http.Request) (*torrent.Torrent, int, *torrent.File, bool) {
+	var ih metainfo.Hash
+	if err := ih.FromHexString(r.PathValue("hash")); err != nil {
+		http.Error(w, "Invalid infohash", http.StatusBadRequest)
+		return nil, 0, nil, false
+	}
+
+	t, ok := tClient.Torrent(ih)
+	if !ok {
+		http.Error(w, "Torrent not found", http.StatusNotFound)
+		return nil, 0, nil, false
+	}
 
 	updateAccess(t.InfoHash().String())
 
-	idx, err := strconv.Atoi(fileIdxStr)
+	idx, err := strconv.Atoi(r.PathValue("fileIdx"))
 	if err != nil {
 		http.Error(w, "Invalid file index", http.StatusBadRequest)
-		return
+		return nil, 0, nil, false
 	}
 
 	files := t.Files()
 	if idx < 0 || idx >= len(files) {
 		http.Error(w, "File index out of bounds", http.StatusNotFound)
-		return
+		return nil, 0, nil, false
 	}
 
-	file := files[idx]
-	log.Printf("[stream] Serving: %s", file.DisplayPath())
+	return t, idx, files[idx], true
+}
 
-	reader := file.NewReader()
-	reader.SetResponsive()
-	reader.SetReadahead(50 * 1024 * 1024)
-	defer reader.Close()
+// setValidators sets a strong ETag for a torrent file. The content behind an
+// info hash and file index never changes, so download managers can safely
+// resume with If-Range.
+func setValidators(w http.ResponseWriter, t *torrent.Torrent, idx int) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, t.InfoHash().HexString(), idx))
+}
 
-	http.ServeContent(w, r, file.DisplayPath(), time.Time{}, reader)
+// torrentModTime is when the torrent was first seen by this server. The
+// client does not keep the creation date of a .torrent and magnets have none,
+// the add time changes whenever a torrent is re-added or restored.
+func torrentModTime(t *torrent.Torrent) time.Time {
+	ih := t.InfoHash().String()
+	if seen, ok := firstSeen.Load(ih); ok {
+		return seen.(time.Time)
+	}
+	seen, err := store.TorrentFirstSeen(ih, time.Now())
+	if err != nil {
+		// A zero time leaves out Last-Modified, the ETag still validates.
+		slog.Warn("Failed to load torrent first seen time", "infohash", ih, "err", err)
+		return time.Time{}
+	}
+	firstSeen.Store(ih, seen)
+	return seen
 }
 
-func resolveAndAdd(sourceUrl string) (*torrent.Torrent, error) {
+func resolveAndAdd(ctx context.Context, sourceUrl string) (*torrent.Torrent, error) {
 	if strings.HasPrefix(sourceUrl, "magnet:") {
 		return tClient.AddMagnet(sourceUrl)
 	}
@@ -190,7 +438,12 @@ func resolveAndAdd(sourceUrl string) (*torrent.Torrent, error) {
 		},
 	}
 
-	resp, err := client.Get(sourceUrl)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	slog.DebugContext(ctx, "Resolving torrent source", "url", sourceUrl)
+	resp, err := client.Do(req)
 	if err != nil {
 		if urlErr, ok := err.(*url.Error); ok && strings.HasPrefix(urlErr.URL, "magnet:") {
 			return tClient.AddMagnet(urlErr.URL)
@@ -227,7 +480,6 @@ func resolveAndAdd(sourceUrl string) (*torrent.Torrent, error) {
 func selectFile(t *torrent.Torrent, season, episode int) (int, *torrent.File) {
 	files := t.Files()
 	var videos []int
-	videoExts := map[string]bool{".mkv": true, ".mp4": true, ".avi": true, ".mov": true, ".wmv": true, ".flv": true, ".webm": true}
 
 	for i, f := range files {
 		ext := strings.ToLower(filepath.Ext(f.Path()))
@@ -271,67 +523,156 @@ func updateAccess(hash string) {
 	lastAccessed.Store(hash, time.Now())
 }
 
+// streamReader keeps a torrent alive for as long as a response is reading
+// from it. It holds a reference in activeStreams until closed and refreshes
+// lastAccessed while bytes are being served.
+type streamReader struct {
+	torrent.Reader
+	hash        string
+	lastRefresh time.Time
+	closeOnce   sync.Once
+}
+
+func newStreamReader(hash string, r torrent.Reader) *streamReader {
+	acquireStream(hash)
+	return &streamReader{Reader: r, hash: hash, lastRefresh: time.Now()}
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && time.Since(r.lastRefresh) > accessRefreshInterval {
+		updateAccess(r.hash)
+		r.lastRefresh = time.Now()
+	}
+	return n, err
+}
+
+func (r *streamReader) Close() error {
+	r.closeOnce.Do(func() { releaseStream(r.hash) })
+	return r.Reader.Close()
+}
+
+func acquireStream(hash string) {
+	activeStreamsMu.Lock()
+	defer activeStreamsMu.Unlock()
+	activeStreams[hash]++
+	updateAccess(hash)
+}
+
+func releaseStream(hash string) {
+	activeStreamsMu.Lock()
+	defer activeStreamsMu.Unlock()
+	activeStreams[hash]--
+	if activeStreams[hash] <= 0 {
+		delete(activeStreams, hash)
+	}
+	updateAccess(hash)
+}
+
+func hasActiveStreams(hash string) bool {
+	activeStreamsMu.Lock()
+	defer activeStreamsMu.Unlock()
+	return activeStreams[hash] > 0
+}
+
+func pinTorrent(hash string, until time.Time) {
+	pinnedUntil.Store(hash, until)
+}
+
+func withPin(hash string, last time.Time) time.Time {
+	if until, ok := pinnedUntil.Load(hash); ok && until.(time.Time).After(last) {
+		return until.(time.Time)
+	}
+	return last
+}
+
+func storageUsed() int64 {
+	var total int64
+	for _, t := range tClient.Torrents() {
+		total += t.BytesCompleted()
+	}
+	return total
+}
+
 func cleanupRoutine() {
 	ticker := time.NewTicker(cleanupInterval)
 	for range ticker.C {
-		var totalSize int64
-		torrents := tClient.Torrents()
-
-		for _, t := range torrents {
-			infoHash := t.InfoHash().String()
-			stats := t.Stats()
-			totalSize += t.BytesCompleted()
-
-			last, ok := lastAccessed.Load(infoHash)
-			if !ok {
-				last = time.Now()
-				lastAccessed.Store(infoHash, last)
-			}
+		cleanupTorrents()
+	}
+}
 
-			lastTime := last.(time.Time)
-			inactiveDur := time.Since(lastTime)
+// cleanupTorrents drops inactive and seeded torrents, then the least recently
+// used ones while over the storage limit. Torrents with open streams stay.
+func cleanupTorrents() {
+	var totalSize int64
+	torrents := tClient.Torrents()
+	s := settings()
 
-			var ratio float64
-			if stats.BytesRead.Int64() > 0 {
-				ratio = float64(stats.BytesWritten.Int64()) / float64(stats.BytesRead.Int64())
-			}
+	for _, t := range torrents {
+		infoHash := t.InfoHash().String()
+		stats := t.Stats()
+		totalSize += t.BytesCompleted()
 
-			if inactiveDur > torrentTTL || ratio >= maxRatio {
-				t.Drop()
-				lastAccessed.Delete(infoHash)
-				continue
-			}
+		if hasActiveStreams(infoHash) {
+			continue
 		}
 
-		maxBytes := int64(cfg.TorrentStorageLimitGB) * 1024 * 1024 * 1024
-		if totalSize > maxBytes {
-			type tSort struct {
-				t    *torrent.Torrent
-				last time.Time
-			}
-			var sorted []tSort
-
-			for _, t := range tClient.Torrents() {
-				last, _ := lastAccessed.Load(t.InfoHash().String())
-				if last == nil {
-					last = time.Time{}
-				}
-				sorted = append(sorted, tSort{t, last.(time.Time)})
+		last, ok := lastAccessed.Load(infoHash)
+		if !ok {
+			last = time.Now()
+			lastAccessed.Store(infoHash, last)
+		}
+
+		lastTime := withPin(infoHash, last.(time.Time))
+		inactiveDur := time.Since(lastTime)
+
+		var ratio float64
+		if stats.BytesRead.Int64() > 0 {
+			ratio = float64(stats.BytesWritten.Int64()) / float64(stats.BytesRead.Int64())
+		}
+
+		if inactiveDur > s.TorrentTTL.Duration {
+			dropTorrent(t, dropInactive)
+			continue
+		}
+		if s.SeedRatio > 0 && ratio >= s.SeedRatio {
+			dropTorrent(t, dropRatio)
+			continue
+		}
+
+		store.TouchTorrent(infoHash, lastTime)
+	}
+
+	maxBytes := int64(s.StorageLimitGB * 1024 * 1024 * 1024)
+	if totalSize > maxBytes {
+		type tSort struct {
+			t    *torrent.Torrent
+			last time.Time
+		}
+		var sorted []tSort
+
+		for _, t := range tClient.Torrents() {
+			last, _ := lastAccessed.Load(t.InfoHash().String())
+			if last == nil {
+				last = time.Time{}
 			}
+			sorted = append(sorted, tSort{t, withPin(t.InfoHash().String(), last.(time.Time))})
+		}
+
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].last.Before(sorted[j].last)
+		})
 
-			sort.Slice(sorted, func(i, j int) bool {
-				return sorted[i].last.Before(sorted[j].last)
-			})
-
-			for _, item := range sorted {
-				if totalSize <= maxBytes {
-					break
-				}
-				size := item.t.BytesCompleted()
-				item.t.Drop()
-				lastAccessed.Delete(item.t.InfoHash().String())
-				totalSize -= size
+		for _, item := range sorted {
+			if totalSize <= maxBytes {
+				break
+			}
+			if hasActiveStreams(item.t.InfoHash().String()) {
+				continue
 			}
+			size := item.t.BytesCompleted()
+			dropTorrent(item.t, dropStorage)
+			totalSize -= size
 		}
 	}
 }
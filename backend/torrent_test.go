@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// useTorrentClient starts an offline torrent client seeding one small video
+// file and returns the torrent.
+func useTorrentClient(t *testing.T) *torrent.Torrent {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Movie.2020.1080p.mkv")
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 1<<16)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: 1 << 14}
+	if err := info.BuildFromFilePath(path); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := torrent.NewDefaultClientConfig()
+	c.DataDir = dir
+	c.ListenPort = 0
+	c.NoDHT = true
+	c.DisableTrackers = true
+	c.NoDefaultPortForwarding = true
+	client, err := torrent.NewClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := tClient
+	tClient = client
+	t.Cleanup(func() {
+		client.Close()
+		tClient = old
+	})
+
+	tor, err := client.AddTorrent(&metainfo.MetaInfo{InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tor
+}
+
+func addTorrent(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleAddTorrent(rec, httptest.NewRequest(http.MethodPost, "/api/torrent", strings.NewReader(body)))
+	return rec
+}
+
+func TestAddTorrentResumesByInfoHash(t *testing.T) {
+	tor := useTorrentClient(t)
+	store = openTestStore(t)
+	ih := tor.InfoHash().HexString()
+
+	rec := addTorrent(t, `{"infoHash": "`+ih+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp streamResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.InfoHash != ih || resp.FileName != "Movie.2020.1080p.mkv" {
+		t.Errorf("response = %+v", resp)
+	}
+	// The saved source is a bare magnet, never an indexer link.
+	recs, err := store.ListTorrents()
+	if err != nil || len(recs) != 1 || recs[0].Source != "magnet:?xt=urn:btih:"+ih {
+		t.Errorf("saved torrents = %+v, %v", recs, err)
+	}
+}
+
+func TestAddTorrentResumesEvictedTorrent(t *testing.T) {
+	tor := useTorrentClient(t)
+	store = openTestStore(t)
+	ih := tor.InfoHash()
+	infoBytes := tor.Metainfo().InfoBytes
+	tor.Drop()
+
+	// Stand in for the peers that would send the metadata of the magnet.
+	go func() {
+		for {
+			if re, ok := tClient.Torrent(ih); ok {
+				re.SetInfoBytes(infoBytes)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	rec := addTorrent(t, `{"infoHash": "`+ih.HexString()+`", "fileIdx": 0}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp streamResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.InfoHash != ih.HexString() || resp.FileIdx != 0 {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestAddTorrentValidatesInfoHash(t *testing.T) {
+	useTorrentClient(t)
+	store = openTestStore(t)
+
+	for _, ih := range []string{
+		"abc",
+		strings.Repeat("a", 40) + "&tr=http://evil.example/announce",
+		strings.Repeat("z", 40),
+	} {
+		body, _ := json.Marshal(addTorrentRequest{InfoHash: ih})
+		if rec := addTorrent(t, string(body)); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d", ih, rec.Code)
+		}
+	}
+
+	// An unknown hash must fall through to the guid and link instead of
+	// waiting for peers.
+	rec := addTorrent(t, `{"infoHash": "`+strings.Repeat("a", 40)+`", "guid": "http://127.0.0.1:1/missing"}`)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "Failed to resolve source") {
+		t.Errorf("unknown hash: status = %d: %s", rec.Code, rec.Body)
+	}
+	if _, ok := tClient.Torrent(metainfo.NewHashFromHex(strings.Repeat("a", 40))); ok {
+		t.Error("unknown hash was added to the client")
+	}
+}
+
+func TestUpdateTorrentSettingsReplacesTrackers(t *testing.T) {
+	tor := useTorrentClient(t)
+	source := "udp://source.example:1337/announce"
+	oldTrackers := []string{"udp://old.example:80/announce", "udp://kept.example:80/announce"}
+	newTrackers := []string{"udp://kept.example:80/announce", "udp://new.example:80/announce"}
+	tor.AddTrackers([][]string{{source}})
+	tor.AddTrackers(trackerTiers(oldTrackers))
+
+	updateTorrentSettings(liveSettings{Trackers: oldTrackers}, liveSettings{Trackers: newTrackers})
+
+	var got []string
+	for _, tier := range tor.Metainfo().AnnounceList {
+		got = append(got, tier...)
+	}
+	slices.Sort(got)
+	want := []string{"udp://kept.example:80/announce", "udp://new.example:80/announce", source}
+	if !slices.Equal(got, want) {
+		t.Errorf("trackers = %v, want %v", got, want)
+	}
+}
+
+func TestCleanupKeepsTorrentsWithOpenStreams(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings liveSettings
+	}{
+		{"inactive", liveSettings{TorrentTTL: duration{time.Nanosecond}, StorageLimitGB: 100}},
+		{"over storage limit", liveSettings{TorrentTTL: duration{time.Hour}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tor := useTorrentClient(t)
+			store = openTestStore(t)
+			useSettings(t, tt.settings)
+			tor.VerifyData()
+			if tor.BytesCompleted() == 0 {
+				t.Fatal("seeded torrent has no completed bytes")
+			}
+			hash := tor.InfoHash().String()
+			inClient := func() bool {
+				_, ok := tClient.Torrent(tor.InfoHash())
+				return ok
+			}
+
+			reader := newStreamReader(hash, tor.Files()[0].NewReader())
+			time.Sleep(time.Millisecond)
+			cleanupTorrents()
+			if !inClient() {
+				t.Fatal("torrent with an open stream was dropped")
+			}
+
+			reader.Close()
+			time.Sleep(time.Millisecond)
+			cleanupTorrents()
+			if inClient() {
+				t.Error("torrent was kept after its last stream closed")
+			}
+		})
+	}
+}
+
+func TestDownloadSupportsRangeAndValidators(t *testing.T) {
+	tor := useTorrentClient(t)
+	store = openTestStore(t)
+	tor.VerifyData()
+	hash := tor.InfoHash().HexString()
+	// Every test torrent has the same hash, forget earlier tests' times.
+	firstSeen.Delete(tor.InfoHash().String())
+
+	download := func(header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/download/"+hash+"/0", nil)
+		req.SetPathValue("hash", hash)
+		req.SetPathValue("fileIdx", "0")
+		maps.Copy(req.Header, header)
+		rec := httptest.NewRecorder()
+		handleDownload(rec, req)
+		return rec
+	}
+
+	rec := download(nil)
+	etag, lastModified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if rec.Code != http.StatusOK || rec.Body.Len() != 1<<16 || etag == "" || lastModified == "" {
+		t.Fatalf("status = %d, %d bytes, ETag %q, Last-Modified %q", rec.Code, rec.Body.Len(), etag, lastModified)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename=Movie.2020.1080p.mkv` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	rec = download(http.Header{"Range": {"bytes=100-199"}})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != strings.Repeat("x", 100) || rec.Header().Get("Content-Range") != "bytes 100-199/65536" {
+		t.Errorf("range: status = %d, Content-Range %q, %d bytes", rec.Code, rec.Header().Get("Content-Range"), rec.Body.Len())
+	}
+	if rec := download(http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status = %d", rec.Code)
+	}
+	if rec := download(http.Header{"If-Modified-Since": {lastModified}}); rec.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: status = %d", rec.Code)
+	}
+	// A resumed download whose validator no longer matches gets the whole file.
+	if rec := download(http.Header{"Range": {"bytes=100-"}, "If-Range": {`"other"`}}); rec.Code != http.StatusOK {
+		t.Errorf("stale If-Range: status = %d", rec.Code)
+	}
+
+	// Re-adding the torrent later, e.g. after a restart, keeps Last-Modified.
+	tor.Drop()
+	firstSeen.Delete(tor.InfoHash().String())
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := tClient.AddTorrent(&metainfo.MetaInfo{InfoBytes: tor.Metainfo().InfoBytes}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := download(nil); rec.Header().Get("Last-Modified") != lastModified {
+		t.Errorf("Last-Modified after re-adding = %q, want %q", rec.Header().Get("Last-Modified"), lastModified)
+	}
+}
@@ -1,5 +1,7 @@
 package main
 
+import "slices"
+
 // DefaultTrackers is a list of public trackers to be added to every torrent.
 // We format this as [][]string because t.AddTrackers expects tiers.
 // We treat every tracker as a separate tier for maximum redundancy.
@@ -120,3 +122,39 @@ var DefaultTrackers = [][]string{
 	{"http://ftp.pet:6969/announce"},
 	{"http://1337.abcvg.info:80/announce"},
 }
+
+func defaultTrackerList() []string {
+	out := make([]string, 0, len(DefaultTrackers))
+	for _, tier := range DefaultTrackers {
+		out = append(out, tier...)
+	}
+	return out
+}
+
+// trackerTiers puts every configured tracker in its own tier, like
+// DefaultTrackers.
+func trackerTiers(trackers []string) [][]string {
+	out := make([][]string, len(trackers))
+	for i, tr := range trackers {
+		out[i] = []string{tr}
+	}
+	return out
+}
+
+// withoutTrackers returns the announce list with the given trackers removed
+// and empty tiers dropped.
+func withoutTrackers(tiers [][]string, trackers []string) [][]string {
+	var out [][]string
+	for _, tier := range tiers {
+		var kept []string
+		for _, tr := range tier {
+			if !slices.Contains(trackers, tr) {
+				kept = append(kept, tr)
+			}
+		}
+		if len(kept) > 0 {
+			out = append(out, kept)
+		}
+	}
+	return out
+}
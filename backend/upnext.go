@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const tmdbDateLayout = "2006-01-02"
+
+type upNextEntry struct {
+	TmdbId       int       `json:"tmdbId"`
+	ShowName     string    `json:"showName"`
+	PosterPath   string    `json:"posterPath"`
+	BackdropPath string    `json:"backdropPath"`
+	Season       int       `json:"season"`
+	Episode      int       `json:"episode"`
+	EpisodeName  string    `json:"episodeName"`
+	AirDate      string    `json:"airDate"`
+	StillPath    string    `json:"stillPath"`
+	Aired        bool      `json:"aired"`
+	Position     float64   `json:"position,omitempty"`
+	LastActivity time.Time `json:"lastActivity"`
+}
+
+type episodeNum struct {
+	Season, Episode int
+}
+
+func (e episodeNum) after(o episodeNum) bool {
+	return e.Season > o.Season || (e.Season == o.Season && e.Episode > o.Episode)
+}
+
+func handleUpNext(w http.ResponseWriter, r *http.Request) {
+	u, _ := currentUser(r)
+	entries, err := upNextForUser(r.Context(), u.Id)
+	if err != nil {
+		http.Error(w, "Failed to load watch history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, entries)
+}
+
+func upNextForUser(ctx context.Context, userId int64) ([]upNextEntry, error) {
+	all, err := store.ListProgress(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	shows := map[int][]progress{}
+	for _, p := range all {
+		if p.Type == "episode" && p.Season > 0 {
+			shows[p.TmdbId] = append(shows[p.TmdbId], p)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, watchlistFetchConcurrency)
+	out := []upNextEntry{}
+
+	for showId, history := range shows {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			entry, ok, err := nextEpisode(ctx, showId, history)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to compute next episode", "show", showId, "err", err)
+				return
+			}
+			if !ok {
+				return
+			}
+			mu.Lock()
+			out = append(out, entry)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].LastActivity.After(out[j].LastActivity)
+	})
+	return out, nil
+}
+
+// nextEpisode returns the episode the user is in the middle of, or else the
+// first episode after the furthest watched one that has not been watched yet.
+// An episode left unfinished before a later one was watched counts as
+// skipped. Specials (season 0) are ignored. ok is false when the user is
+// caught up and TMDB knows of no upcoming episode.
+func nextEpisode(ctx context.Context, showId int, history []progress) (upNextEntry, bool, error) {
+	show, err := metadata.Show(ctx, showId)
+	if err != nil {
+		return upNextEntry{}, false, err
+	}
+
+	entry := upNextEntry{
+		TmdbId:       showId,
+		ShowName:     show.Name,
+		PosterPath:   show.PosterPath,
+		BackdropPath: show.BackdropPath,
+	}
+
+	var furthest, resume episodeNum
+	var furthestAt, resumeAt time.Time
+	watched := map[episodeNum]bool{}
+	inProgress := map[episodeNum]float64{}
+	for _, p := range history {
+		if p.Season == 0 {
+			continue
+		}
+		n := episodeNum{p.Season, p.Episode}
+		if p.UpdatedAt.After(entry.LastActivity) {
+			entry.LastActivity = p.UpdatedAt
+		}
+		if p.Watched {
+			watched[n] = true
+			if n.after(furthest) {
+				furthest, furthestAt = n, p.UpdatedAt
+			}
+		} else if p.Position > 0 {
+			inProgress[n] = p.Position
+			if p.UpdatedAt.After(resumeAt) {
+				resume, resumeAt = n, p.UpdatedAt
+			}
+		}
+	}
+
+	today := time.Now().Format(tmdbDateLayout)
+	if resume.Season > 0 && (resume.after(furthest) || resumeAt.After(furthestAt)) {
+		season, err := metadata.Season(ctx, showId, resume.Season)
+		if err != nil {
+			return upNextEntry{}, false, err
+		}
+		for _, ep := range season.Episodes {
+			if ep.EpisodeNumber == resume.Episode {
+				entry.setEpisode(ep, resume.Season, today)
+				entry.Position = inProgress[resume]
+				return entry, true, nil
+			}
+		}
+	}
+
+	sort.Slice(show.Seasons, func(i, j int) bool {
+		return show.Seasons[i].SeasonNumber < show.Seasons[j].SeasonNumber
+	})
+
+	for _, s := range show.Seasons {
+		if s.SeasonNumber == 0 || s.SeasonNumber < furthest.Season {
+			continue
+		}
+
+		season, err := metadata.Season(ctx, showId, s.SeasonNumber)
+		if err != nil {
+			return upNextEntry{}, false, err
+		}
+
+		for _, ep := range season.Episodes {
+			n := episodeNum{s.SeasonNumber, ep.EpisodeNumber}
+			if !n.after(furthest) || watched[n] {
+				continue
+			}
+			entry.setEpisode(ep, s.SeasonNumber, today)
+			entry.Position = inProgress[n]
+			return entry, true, nil
+		}
+	}
+
+	if next := show.NextEpisodeToAir; next != nil && (episodeNum{next.SeasonNumber, next.EpisodeNumber}).after(furthest) {
+		entry.setEpisode(*next, next.SeasonNumber, today)
+		return entry, true, nil
+	}
+	return entry, false, nil
+}
+
+func (e *upNextEntry) setEpisode(ep tmdbEpisodeSummary, season int, today string) {
+	e.Season = season
+	e.Episode = ep.EpisodeNumber
+	e.EpisodeName = ep.Name
+	e.AirDate = ep.AirDate
+	e.StillPath = ep.StillPath
+	e.Aired = ep.AirDate != "" && ep.AirDate <= today
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useShowFixtures serves show 77 from fixture files: three specials in
+// season 0 and three aired episodes in seasons 1 and 2.
+func useShowFixtures(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name string, v any) {
+		path := filepath.Join(dir, name+".json")
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(v)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var seasons []map[string]any
+	for season := range 3 {
+		seasons = append(seasons, map[string]any{"season_number": season})
+		var episodes []map[string]any
+		for ep := 1; ep <= 3; ep++ {
+			episodes = append(episodes, map[string]any{
+				"season_number":  season,
+				"episode_number": ep,
+				"name":           fmt.Sprintf("S%dE%d", season, ep),
+				"air_date":       fmt.Sprintf("2020-%02d-%02d", season+1, ep),
+			})
+		}
+		write(fmt.Sprintf("tv/77/season/%d", season), map[string]any{"season_number": season, "episodes": episodes})
+	}
+	write("tv/77", map[string]any{"id": 77, "name": "Show", "seasons": seasons})
+	useMetadata(t, fixtureProvider{dir: dir})
+}
+
+func TestNextEpisode(t *testing.T) {
+	useShowFixtures(t)
+
+	day := func(n int) time.Time { return time.Date(2024, 1, n, 0, 0, 0, 0, time.UTC) }
+	watched := func(season, episode, on int) progress {
+		return progress{mediaKey: mediaKey{Type: "episode", TmdbId: 77, Season: season, Episode: episode}, Watched: true, Position: 1000, Duration: 1000, UpdatedAt: day(on)}
+	}
+	started := func(season, episode, on int) progress {
+		return progress{mediaKey: mediaKey{Type: "episode", TmdbId: 77, Season: season, Episode: episode}, Position: 400, Duration: 1000, UpdatedAt: day(on)}
+	}
+
+	tests := []struct {
+		name     string
+		history  []progress
+		want     episodeNum
+		position float64
+		ok       bool
+	}{
+		{"fully watched season", []progress{watched(1, 1, 1), watched(1, 2, 2), watched(1, 3, 3)}, episodeNum{2, 1}, 0, true},
+		{"watched with gaps", []progress{watched(1, 1, 1), watched(1, 3, 2)}, episodeNum{2, 1}, 0, true},
+		{"in progress only", []progress{started(2, 3, 1)}, episodeNum{2, 3}, 400, true},
+		{"in progress after watched", []progress{watched(1, 1, 1), started(1, 2, 2)}, episodeNum{1, 2}, 400, true},
+		{"rewatching an earlier episode", []progress{watched(2, 2, 1), started(1, 1, 2)}, episodeNum{1, 1}, 400, true},
+		{"abandoned for a later episode", []progress{started(1, 1, 1), watched(1, 2, 2)}, episodeNum{1, 3}, 0, true},
+		{"season 0 special watched", []progress{watched(0, 2, 1)}, episodeNum{1, 1}, 0, true},
+		{"season 0 special in progress", []progress{watched(1, 1, 1), started(0, 1, 2)}, episodeNum{1, 2}, 0, true},
+		{"caught up", []progress{watched(2, 3, 1)}, episodeNum{}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := nextEpisode(context.Background(), 77, tt.history)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if n := (episodeNum{got.Season, got.Episode}); n != tt.want || got.Position != tt.position {
+				t.Errorf("next = %+v at %v, want %+v at %v", n, got.Position, tt.want, tt.position)
+			}
+			if !got.Aired || got.EpisodeName != fmt.Sprintf("S%dE%d", tt.want.Season, tt.want.Episode) {
+				t.Errorf("entry = %+v", got)
+			}
+		})
+	}
+}
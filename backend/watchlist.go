@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const watchlistFetchConcurrency = 8
+
+// Users have two lists that share the same table: the watchlist and their
+// favourites.
+const (
+	listWatchlist = "watchlist"
+	listFavorites = "favorites"
+)
+
+type WatchlistRepository interface {
+	AddToList(userId int64, list, mediaType string, tmdbId int) error
+	RemoveFromList(userId int64, list, mediaType string, tmdbId int) error
+	ListItems(userId int64, list string) ([]watchlistItem, error)
+	ListAllItems(list, mediaType string) ([]listEntry, error)
+}
+
+// listEntry is a list item without enrichment, used by background jobs that
+// work across all users.
+type listEntry struct {
+	UserId int64
+	TmdbId int
+}
+
+type watchlistItem struct {
+	Type         string    `json:"type"`
+	TmdbId       int       `json:"tmdbId"`
+	AddedAt      time.Time `json:"addedAt"`
+	Title        string    `json:"title"`
+	PosterPath   string    `json:"posterPath"`
+	BackdropPath string    `json:"backdropPath"`
+	ReleaseDate  string    `json:"releaseDate"`
+}
+
+type watchlistRequest struct {
+	Type   string `json:"type"`
+	TmdbId int    `json:"tmdbId"`
+}
+
+func validWatchlistType(t string) bool {
+	return t == "movie" || t == "show"
+}
+
+func handleList(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := currentUser(r)
+		items, err := store.ListItems(u.Id, list)
+		if err != nil {
+			http.Error(w, "Failed to load "+list, http.StatusInternalServerError)
+			return
+		}
+
+		enrichWatchlist(r.Context(), items)
+		writeJSON(w, items)
+	}
+}
+
+func handleAddToList(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req watchlistRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if !validWatchlistType(req.Type) || req.TmdbId <= 0 {
+			http.Error(w, "Invalid type or tmdbId", http.StatusBadRequest)
+			return
+		}
+
+		u, _ := currentUser(r)
+		if err := store.AddToList(u.Id, list, req.Type, req.TmdbId); err != nil {
+			http.Error(w, "Failed to update "+list, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleRemoveFromList(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType := r.PathValue("type")
+		tmdbId, err := strconv.Atoi(r.PathValue("tmdbId"))
+		if !validWatchlistType(mediaType) || err != nil {
+			http.Error(w, "Invalid type or tmdbId", http.StatusBadRequest)
+			return
+		}
+
+		u, _ := currentUser(r)
+		if err := store.RemoveFromList(u.Id, list, mediaType, tmdbId); err != nil {
+			http.Error(w, "Failed to update "+list, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// enrichWatchlist fills in titles and artwork from the metadata provider.
+// TMDB responses are cached, so only the first load after an add hits TMDB.
+func enrichWatchlist(ctx context.Context, items []watchlistItem) {
+	sem := make(chan struct{}, watchlistFetchConcurrency)
+	var wg sync.WaitGroup
+
+	for i := range items {
+		wg.Add(1)
+		go func(item *watchlistItem) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if item.Type == "show" {
+				show, err := metadata.Show(ctx, item.TmdbId)
+				if err != nil {
+					slog.WarnContext(ctx, "Failed to fetch watchlist details", "show", item.TmdbId, "err", err)
+					return
+				}
+				item.Title, item.ReleaseDate = show.Name, show.FirstAirDate
+				item.PosterPath, item.BackdropPath = show.PosterPath, show.BackdropPath
+				return
+			}
+
+			movie, err := metadata.Movie(ctx, item.TmdbId)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to fetch watchlist details", "movie", item.TmdbId, "err", err)
+				return
+			}
+			item.Title, item.ReleaseDate = movie.Title, movie.ReleaseDate
+			item.PosterPath, item.BackdropPath = movie.PosterPath, movie.BackdropPath
+		}(&items[i])
+	}
+	wg.Wait()
+}
+
+func (s *Store) AddToList(userId int64, list, mediaType string, tmdbId int) error {
+	_, err := s.db.Exec(
+		"INSERT INTO watchlist (user_id, list, media_type, tmdb_id, added_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		userId, list, mediaType, tmdbId, time.Now().Unix(),
+	)
+	return err
+}
+
+func (s *Store) RemoveFromList(userId int64, list, mediaType string, tmdbId int) error {
+	_, err := s.db.Exec(
+		"DELETE FROM watchlist WHERE user_id = ? AND list = ? AND media_type = ? AND tmdb_id = ?",
+		userId, list, mediaType, tmdbId,
+	)
+	return err
+}
+
+func (s *Store) ListItems(userId int64, list string) ([]watchlistItem, error) {
+	rows, err := s.db.Query(
+		"SELECT media_type, tmdb_id, added_at FROM watchlist WHERE user_id = ? AND list = ? ORDER BY added_at DESC",
+		userId, list,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []watchlistItem{}
+	for rows.Next() {
+		var item watchlistItem
+		var addedAt int64
+		if err := rows.Scan(&item.Type, &item.TmdbId, &addedAt); err != nil {
+			return nil, err
+		}
+		item.AddedAt = time.Unix(addedAt, 0)
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) ListAllItems(list, mediaType string) ([]listEntry, error) {
+	rows, err := s.db.Query(
+		"SELECT user_id, tmdb_id FROM watchlist WHERE list = ? AND media_type = ? ORDER BY added_at",
+		list, mediaType,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []listEntry
+	for rows.Next() {
+		var e listEntry
+		if err := rows.Scan(&e.UserId, &e.TmdbId); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWatchlistHandlers(t *testing.T) {
+	alice := useProgressStore(t)
+	bob, err := store.CreateUser("bob", "hash", roleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useMetadata(t, fixtureProvider{dir: "testdata/metadata"})
+
+	add := func(u user, list, body string) int {
+		return serveAs(u, handleAddToList(list), http.MethodPost, "/api/"+list, body).Code
+	}
+	remove := func(u user, list, mediaType, tmdbId string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/"+list+"/"+mediaType+"/"+tmdbId, nil)
+		req = req.WithContext(context.WithValue(req.Context(), userCtxKey{}, u))
+		req.SetPathValue("type", mediaType)
+		req.SetPathValue("tmdbId", tmdbId)
+		rec := httptest.NewRecorder()
+		handleRemoveFromList(list)(rec, req)
+		return rec.Code
+	}
+	titles := func(u user, list string) []string {
+		t.Helper()
+		rec := serveAs(u, handleList(list), http.MethodGet, "/api/"+list, "")
+		var items []watchlistItem
+		if err := json.NewDecoder(rec.Body).Decode(&items); err != nil {
+			t.Fatal(err)
+		}
+		out := []string{}
+		for _, item := range items {
+			out = append(out, item.Title)
+		}
+		slices.Sort(out)
+		return out
+	}
+
+	for _, req := range []struct {
+		u          user
+		list, body string
+	}{
+		{alice, listWatchlist, `{"type": "movie", "tmdbId": 603}`},
+		{alice, listWatchlist, `{"type": "show", "tmdbId": 1399}`},
+		{alice, listWatchlist, `{"type": "movie", "tmdbId": 603}`},
+		{alice, listFavorites, `{"type": "movie", "tmdbId": 604}`},
+		{bob, listWatchlist, `{"type": "movie", "tmdbId": 604}`},
+	} {
+		if code := add(req.u, req.list, req.body); code != http.StatusNoContent {
+			t.Errorf("add %s to %s: status = %d", req.body, req.list, code)
+		}
+	}
+	for _, body := range []string{`not json`, `{"type": "person", "tmdbId": 1}`, `{"type": "movie", "tmdbId": 0}`} {
+		if code := add(alice, listWatchlist, body); code != http.StatusBadRequest {
+			t.Errorf("add %s: status = %d", body, code)
+		}
+	}
+
+	tests := []struct {
+		u    user
+		list string
+		want []string
+	}{
+		{alice, listWatchlist, []string{"Game of Thrones", "The Matrix"}},
+		{alice, listFavorites, []string{"The Matrix Reloaded"}},
+		{bob, listWatchlist, []string{"The Matrix Reloaded"}},
+		{bob, listFavorites, []string{}},
+	}
+	for _, tt := range tests {
+		if got := titles(tt.u, tt.list); !slices.Equal(got, tt.want) {
+			t.Errorf("%s's %s = %v, want %v", tt.u.Username, tt.list, got, tt.want)
+		}
+	}
+
+	// Removing only touches the user's own list.
+	if code := remove(bob, listWatchlist, "movie", "603"); code != http.StatusNoContent {
+		t.Errorf("remove missing item: status = %d", code)
+	}
+	if code := remove(alice, listWatchlist, "movie", "603"); code != http.StatusNoContent {
+		t.Errorf("remove: status = %d", code)
+	}
+	for _, bad := range [][2]string{{"person", "603"}, {"movie", "abc"}} {
+		if code := remove(alice, listWatchlist, bad[0], bad[1]); code != http.StatusBadRequest {
+			t.Errorf("remove %v: status = %d", bad, code)
+		}
+	}
+	if got := titles(alice, listWatchlist); !slices.Equal(got, []string{"Game of Thrones"}) {
+		t.Errorf("alice's watchlist after removing = %v", got)
+	}
+	if got := titles(bob, listWatchlist); !slices.Equal(got, []string{"The Matrix Reloaded"}) {
+		t.Errorf("bob's watchlist after alice removed = %v", got)
+	}
+}
+
+func TestEnrichWatchlistKeepsItemsWithoutDetails(t *testing.T) {
+	useMetadata(t, fixtureProvider{dir: "testdata/metadata"})
+	items := []watchlistItem{{Type: "movie", TmdbId: 603}, {Type: "movie", TmdbId: 1}, {Type: "show", TmdbId: 1399}}
+	enrichWatchlist(t.Context(), items)
+
+	if items[0].Title != "The Matrix" || !strings.HasPrefix(items[0].ReleaseDate, "1999") || items[0].PosterPath == "" {
+		t.Errorf("movie = %+v", items[0])
+	}
+	if items[1].Title != "" || items[1].TmdbId != 1 {
+		t.Errorf("unknown movie = %+v", items[1])
+	}
+	if items[2].Title != "Game of Thrones" {
+		t.Errorf("show = %+v", items[2])
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+const (
+	eventTorrentAdded     = "torrent.added"
+	eventTorrentMetadata  = "torrent.metadata"
+	eventTorrentCompleted = "torrent.completed"
+	eventTorrentDropped   = "torrent.dropped"
+	eventPlaybackStarted  = "playback.started"
+	eventPlaybackFinished = "playback.finished"
+
+	webhookMaxAttempts     = 5
+	webhookDeliveryTimeout = 10 * time.Second
+	webhookLogLimit        = 100
+	webhookLogRetention    = 30 * 24 * time.Hour
+	// playbackSessionGap is how long progress reports may pause before the
+	// next one counts as a new playback.
+	playbackSessionGap  = 30 * time.Minute
+	completionPollDelay = 30 * time.Second
+)
+
+// webhookBackoff is the delay before the first retry, doubled on every
+// further attempt.
+var webhookBackoff = 5 * time.Second
+
+type WebhookRepository interface {
+	LogWebhookDelivery(d webhookDelivery) error
+	ListWebhookDeliveries(limit int) ([]webhookDelivery, error)
+}
+
+type webhookEvent struct {
+	Id        string    `json:"id"`
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      any       `json:"data"`
+}
+
+// webhookDelivery is one attempt to deliver an event to a URL.
+type webhookDelivery struct {
+	EventId    string    `json:"eventId"`
+	Event      string    `json:"event"`
+	Url        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type torrentEventData struct {
+	InfoHash string `json:"infoHash"`
+	Name     string `json:"name"`
+	FileIdx  *int   `json:"fileIdx,omitempty"`
+	FileName string `json:"fileName,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type playbackEventData struct {
+	mediaKey
+	User     string  `json:"user"`
+	InfoHash string  `json:"infoHash,omitempty"`
+	FileIdx  int     `json:"fileIdx"`
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration"`
+}
+
+// emitEvent sends an event to every configured webhook in the background.
+func emitEvent(event string, data any) {
+	if len(cfg.WebhookUrls) == 0 {
+		return
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	e := webhookEvent{Id: hex.EncodeToString(id), Event: event, Timestamp: time.Now().UTC(), Data: data}
+	body, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("Failed to encode webhook event", "event", event, "err", err)
+		return
+	}
+
+	for _, url := range cfg.WebhookUrls {
+		go deliverWebhook(url, e, body)
+	}
+}
+
+// deliverWebhook posts body to url, retrying with exponential backoff.
+// Every attempt is recorded in the delivery log.
+func deliverWebhook(url string, e webhookEvent, body []byte) bool {
+	delay := webhookBackoff
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		status, err := postWebhook(url, e, body)
+
+		d := webhookDelivery{EventId: e.Id, Event: e.Event, Url: url, Attempt: attempt, StatusCode: status, CreatedAt: time.Now()}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		if logErr := store.LogWebhookDelivery(d); logErr != nil {
+			slog.Error("Failed to log webhook delivery", "err", logErr)
+		}
+
+		if err == nil {
+			return true
+		}
+		slog.Warn("Webhook delivery failed", "event", e.Event, "url", url, "attempt", attempt, "max_attempts", webhookMaxAttempts, "err", err)
+		if attempt < webhookMaxAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return false
+}
+
+func postWebhook(url string, e webhookEvent, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookDeliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Kiroshi-Webhook")
+	req.Header.Set("X-Kiroshi-Event", e.Event)
+	req.Header.Set("X-Kiroshi-Delivery", e.Id)
+	if cfg.WebhookSecret != "" {
+		req.Header.Set("X-Kiroshi-Signature", "sha256="+signWebhook(cfg.WebhookSecret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook returns the hex encoded HMAC-SHA256 of body, receivers verify
+// it against the X-Kiroshi-Signature header.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func torrentEvent(t *torrent.Torrent, reason string) torrentEventData {
+	return torrentEventData{InfoHash: t.InfoHash().String(), Name: t.Name(), Reason: reason}
+}
+
+// watchTorrent emits the metadata event once the info is known and a
+// completion event for every wanted file that finishes downloading. Files
+// that are already complete when the watch starts are not reported again.
+func watchTorrent(t *torrent.Torrent, announceMetadata bool) {
+	select {
+	case <-t.GotInfo():
+	case <-t.Closed():
+		return
+	}
+	if announceMetadata {
+		emitEvent(eventTorrentMetadata, torrentEvent(t, ""))
+	}
+
+	reported := map[int]bool{}
+	for i, f := range t.Files() {
+		if f.BytesCompleted() == f.Length() {
+			reported[i] = true
+		}
+	}
+
+	ticker := time.NewTicker(completionPollDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.Closed():
+			return
+		}
+		for i, f := range t.Files() {
+			if reported[i] || f.Priority() == torrent.PiecePriorityNone || f.BytesCompleted() < f.Length() {
+				continue
+			}
+			reported[i] = true
+			data := torrentEvent(t, "")
+			data.FileIdx = &i
+			data.FileName = f.DisplayPath()
+			emitEvent(eventTorrentCompleted, data)
+		}
+	}
+}
+
+// emitPlaybackEvents compares a progress report with the previous one and
+// emits started and finished events.
+func emitPlaybackEvents(u user, prev progress, hadPrev bool, p progress) {
+	data := playbackEventData{
+		mediaKey: p.mediaKey,
+		User:     u.Username,
+		InfoHash: p.InfoHash,
+		FileIdx:  p.FileIdx,
+		Position: p.Position,
+		Duration: p.Duration,
+	}
+	finished := p.Position/p.Duration >= cfg.WatchedThreshold
+	prevFinished := hadPrev && prev.Duration > 0 && prev.Position/prev.Duration >= cfg.WatchedThreshold
+
+	if !hadPrev || p.UpdatedAt.Sub(prev.UpdatedAt) > playbackSessionGap || (prevFinished && !finished) {
+		emitEvent(eventPlaybackStarted, data)
+	}
+	if finished && !prevFinished {
+		emitEvent(eventPlaybackFinished, data)
+	}
+}
+
+func handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit := webhookLogLimit
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 1000 {
+		limit = n
+	}
+	list, err := store.ListWebhookDeliveries(limit)
+	if err != nil {
+		http.Error(w, "Failed to load deliveries", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, list)
+}
+
+// LogWebhookDelivery records an attempt and prunes entries older than
+// webhookLogRetention.
+func (s *Store) LogWebhookDelivery(d webhookDelivery) error {
+	_, err := s.db.Exec(
+		"INSERT INTO webhook_deliveries (event_id, event, url, attempt, status_code, error, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		d.EventId, d.Event, d.Url, d.Attempt, d.StatusCode, d.Error, d.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("DELETE FROM webhook_deliveries WHERE created_at < ?", d.CreatedAt.Add(-webhookLogRetention).Unix())
+	return err
+}
+
+// ListWebhookDeliveries returns the most recent delivery attempts first.
+func (s *Store) ListWebhookDeliveries(limit int) ([]webhookDelivery, error) {
+	rows, err := s.db.Query(
+		"SELECT event_id, event, url, attempt, status_code, error, created_at FROM webhook_deliveries ORDER BY id DESC LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []webhookDelivery{}
+	for rows.Next() {
+		var d webhookDelivery
+		var created int64
+		if err := rows.Scan(&d.EventId, &d.Event, &d.Url, &d.Attempt, &d.StatusCode, &d.Error, &created); err != nil {
+			return nil, err
+		}
+		d.CreatedAt = time.Unix(created, 0)
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
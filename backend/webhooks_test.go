@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeliverWebhookSignsAndRetries(t *testing.T) {
+	oldCfg, oldStore, oldBackoff := cfg, store, webhookBackoff
+	t.Cleanup(func() { cfg, store, webhookBackoff = oldCfg, oldStore, oldBackoff })
+	store = openTestStore(t)
+	webhookBackoff = time.Millisecond
+	cfg.WebhookSecret = "s3cret"
+
+	var attempts int
+	var gotSig, gotEvent string
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		gotSig = r.Header.Get("X-Kiroshi-Signature")
+		gotEvent = r.Header.Get("X-Kiroshi-Event")
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	e := webhookEvent{Id: "abc", Event: eventTorrentDropped, Timestamp: time.Now(), Data: torrentEventData{InfoHash: "ff", Reason: dropStorage}}
+	body, _ := json.Marshal(e)
+	if !deliverWebhook(server.URL, e, body) {
+		t.Fatal("delivery failed")
+	}
+
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	if gotEvent != eventTorrentDropped {
+		t.Errorf("event header = %q", gotEvent)
+	}
+	if want := "sha256=" + signWebhook("s3cret", gotBody); gotSig != want {
+		t.Errorf("signature = %q, want %q", gotSig, want)
+	}
+
+	var payload struct {
+		Data torrentEventData `json:"data"`
+	}
+	json.Unmarshal(gotBody, &payload)
+	if payload.Data.Reason != dropStorage {
+		t.Errorf("reason = %q", payload.Data.Reason)
+	}
+
+	log, err := store.ListWebhookDeliveries(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 3 {
+		t.Fatalf("logged %d attempts, want 3", len(log))
+	}
+	if log[0].Attempt != 3 || log[0].Error != "" || log[0].StatusCode != http.StatusOK {
+		t.Errorf("latest entry = %+v", log[0])
+	}
+	if log[2].Attempt != 1 || log[2].StatusCode != http.StatusBadGateway || log[2].Error == "" {
+		t.Errorf("first entry = %+v", log[2])
+	}
+}
+
+func TestDeliverWebhookGivesUp(t *testing.T) {
+	oldStore, oldBackoff := store, webhookBackoff
+	t.Cleanup(func() { store, webhookBackoff = oldStore, oldBackoff })
+	store = openTestStore(t)
+	webhookBackoff = time.Millisecond
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if deliverWebhook(server.URL, webhookEvent{Id: "x", Event: eventTorrentAdded}, []byte("{}")) {
+		t.Fatal("delivery should have failed")
+	}
+	log, _ := store.ListWebhookDeliveries(10)
+	if len(log) != webhookMaxAttempts {
+		t.Errorf("logged %d attempts, want %d", len(log), webhookMaxAttempts)
+	}
+}
+
+func TestPlaybackEvents(t *testing.T) {
+	oldCfg, oldStore := cfg, store
+	t.Cleanup(func() { cfg, store = oldCfg, oldStore })
+	store = openTestStore(t)
+
+	var mu sync.Mutex
+	var events []string
+	done := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		events = append(events, r.Header.Get("X-Kiroshi-Event"))
+		mu.Unlock()
+		done <- struct{}{}
+	}))
+	defer server.Close()
+	cfg.WebhookUrls = []string{server.URL}
+	cfg.WatchedThreshold = 0.9
+
+	u := user{Id: 1, Username: "alice"}
+	key := mediaKey{Type: "movie", TmdbId: 603}
+	now := time.Now()
+	first := progress{mediaKey: key, Position: 15, Duration: 100, UpdatedAt: now}
+	mid := progress{mediaKey: key, Position: 50, Duration: 100, UpdatedAt: now.Add(time.Minute)}
+	end := progress{mediaKey: key, Position: 95, Duration: 100, UpdatedAt: now.Add(2 * time.Minute)}
+
+	emitPlaybackEvents(u, progress{}, false, first)
+	emitPlaybackEvents(u, first, true, mid)
+	emitPlaybackEvents(u, mid, true, end)
+
+	for range 2 {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for webhooks")
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("events = %v, want one started and one finished", events)
+	}
+	got := map[string]bool{events[0]: true, events[1]: true}
+	if !got[eventPlaybackStarted] || !got[eventPlaybackFinished] {
+		t.Errorf("events = %v", events)
+	}
+}
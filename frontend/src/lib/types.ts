@@ -1,6 +1,7 @@
 export interface MovieInfo {
     mediaType: 'movie',
     title: string,
+    originalTitle: string,
     releaseDate: string,
     tmdbId: string,
     imdbId: string,
@@ -10,6 +11,7 @@ export interface MovieInfo {
 export interface EpisodeInfo {
     mediaType: 'episode',
     showName: string,
+    originalShowName?: string,
     tmdbId: string,
     imdbId: string,
     episodeImdbId: string,
@@ -29,4 +31,94 @@ export interface ShowInfo {
     releaseDate: string,
     backdropPath: string,
     episodeInfos: EpisodeInfo[][],
-}
\ No newline at end of file
+}
+// Response types of the metadata endpoints (/api/search, /api/movie, ...)
+
+export interface MediaSummary {
+    id: number,
+    type: 'movie' | 'show',
+    title: string,
+    originalTitle: string,
+    overview: string,
+    releaseDate: string,
+    posterPath: string,
+    backdropPath: string,
+    rating: number,
+}
+
+export interface CastMember {
+    id: number,
+    name: string,
+    character: string,
+    profilePath: string,
+}
+
+export interface Video {
+    name: string,
+    site: string,
+    key: string,
+    type: string,
+}
+
+export interface CollectionSummary {
+    id: number,
+    name: string,
+    posterPath: string,
+}
+
+export interface MovieDetails extends MediaSummary {
+    imdbId: string,
+    tagline: string,
+    runtime: number,
+    genres: string[],
+    collection: CollectionSummary | null,
+    cast: CastMember[],
+    videos: Video[],
+}
+
+export interface EpisodeSummary {
+    season: number,
+    episode: number,
+    name: string,
+    overview: string,
+    airDate: string,
+    stillPath: string,
+    runtime: number,
+    rating: number,
+}
+
+export interface SeasonSummary {
+    season: number,
+    name: string,
+    episodeCount: number,
+    airDate: string,
+    posterPath: string,
+}
+
+export interface ShowDetails extends MediaSummary {
+    imdbId: string,
+    lastAirDate: string,
+    status: string,
+    genres: string[],
+    seasons: SeasonSummary[],
+    nextEpisode: EpisodeSummary | null,
+    cast: CastMember[],
+    videos: Video[],
+}
+
+export interface SeasonDetails {
+    showId: number,
+    season: number,
+    name: string,
+    overview: string,
+    airDate: string,
+    posterPath: string,
+    episodes: EpisodeSummary[],
+}
+
+export interface EpisodeDetails extends EpisodeSummary {
+    showId: number,
+    imdbId: string,
+    cast: CastMember[],
+    guestStars: CastMember[],
+}
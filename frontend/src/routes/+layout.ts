@@ -1 +1,13 @@
+import { redirect } from '@sveltejs/kit';
+import type { LayoutLoad } from './$types';
+
 export const ssr = false;
+
+export const load: LayoutLoad = async ({ fetch, url }) => {
+    const res = await fetch('/api/me');
+    if (res.status === 401 && url.pathname !== '/login') {
+        throw redirect(307, `/login?redirect=${encodeURIComponent(url.pathname + url.search)}`);
+    }
+
+    return { user: res.ok ? await res.json() : null };
+};
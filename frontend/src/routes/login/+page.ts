@@ -0,0 +1,11 @@
+import type { PageLoad } from './$types';
+
+export const load: PageLoad = async ({ fetch, url }) => {
+    const res = await fetch('/api/setup');
+    const setup = await res.json();
+
+    return {
+        setupRequired: setup.required as boolean,
+        redirectTo: url.searchParams.get('redirect') ?? '/'
+    };
+};
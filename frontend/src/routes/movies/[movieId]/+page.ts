@@ -1,4 +1,5 @@
-import type { MovieInfo } from '$lib/types';
+import type { MovieDetails, MovieInfo } from '$lib/types';
+import { error } from '@sveltejs/kit';
 import type { PageLoad } from './$types';
 
 type LoadOutput = {
@@ -7,15 +8,21 @@ type LoadOutput = {
 
 export const load: PageLoad<LoadOutput> = async ({ params, fetch }) => {
     const res = await fetch(`/api/movie?id=${encodeURIComponent(params.movieId)}`);
-    const movieDetails = await res.json();
+    if (!res.ok) {
+        throw error(404, {
+            message: 'Requested movie does not exist.'
+        });
+    }
+    const movieDetails: MovieDetails = await res.json();
 
     const movieInfo: MovieInfo = {
         mediaType: 'movie', 
         title: movieDetails.title,
-        releaseDate: movieDetails.release_date,
+        originalTitle: movieDetails.originalTitle,
+        releaseDate: movieDetails.releaseDate,
         tmdbId: params.movieId,
-        imdbId: movieDetails.imdb_id,
-        backdropPath: movieDetails.backdrop_path,
+        imdbId: movieDetails.imdbId,
+        backdropPath: movieDetails.backdropPath,
     };
 
     return { movieInfo };
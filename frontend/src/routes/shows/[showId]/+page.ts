@@ -1,36 +1,35 @@
-import type { ShowInfo } from '$lib/types';
+import type { SeasonDetails, ShowDetails, ShowInfo } from '$lib/types';
 import { error } from '@sveltejs/kit';
 import type { PageLoad } from './$types';
 
 type LoadOutput = {
     showInfo: ShowInfo,
-    episodeInfosPromise: Promise<any[]>
+    episodeInfosPromise: Promise<SeasonDetails[]>
 };
 
 export const load: PageLoad<LoadOutput> = async ({ params, fetch }) => {
     const showRes = await fetch(`/api/show?id=${encodeURIComponent(params.showId)}`);
-    const showDetails = await showRes.json();
-
-    if (!showDetails) {
+    if (!showRes.ok) {
         throw error(404, {
             message: 'Requested show does not exist.'
         });
     }
+    const showDetails: ShowDetails = await showRes.json();
 
     const showInfo: ShowInfo = {
-        title: showDetails.name,
+        title: showDetails.title,
         tmdbId: params.showId,
-        imdbId: showDetails.imdb_id,
-        releaseDate: showDetails.first_air_date,
-        backdropPath: showDetails.backdrop_path,
+        imdbId: showDetails.imdbId,
+        releaseDate: showDetails.releaseDate,
+        backdropPath: showDetails.backdropPath,
         episodeInfos: [],
     };
 
     return {
         showInfo,
         episodeInfosPromise: (async () => {
-            const promises = showDetails.seasons.map((s: any) =>
-                fetch(`/api/season?id=${encodeURIComponent(params.showId)}&season=${s.season_number}`).then(r => r.json())
+            const promises = showDetails.seasons.map((s) =>
+                fetch(`/api/season?id=${encodeURIComponent(params.showId)}&season=${s.season}`).then(r => r.json())
             );
             return await Promise.all(promises);
         })()
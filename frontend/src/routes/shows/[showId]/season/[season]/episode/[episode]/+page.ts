@@ -1,4 +1,4 @@
-import type { EpisodeInfo, ShowInfo } from '$lib/types';
+import type { EpisodeDetails, EpisodeInfo, ShowDetails } from '$lib/types';
 import { error } from '@sveltejs/kit';
 import type { PageLoad } from './$types';
 
@@ -12,26 +12,26 @@ export const load: PageLoad<LoadOutput> = async ({ params, fetch }) => {
         fetch(`/api/episode?id=${encodeURIComponent(params.showId)}&season=${params.season}&episode=${params.episode}`)
     ]);
 
-    const showDetails = await showRes.json();
-    const episodeDetails = await episodeRes.json();
-
-    if (!episodeDetails || !episodeDetails.name) {
+    if (!showRes.ok || !episodeRes.ok) {
         throw error(404, {
             message: 'Requested episode does not exist.'
         });
     }
+    const showDetails: ShowDetails = await showRes.json();
+    const episodeDetails: EpisodeDetails = await episodeRes.json();
 
     const episodeInfo: EpisodeInfo = {
         mediaType: 'episode',
-        showName: showDetails.name,
+        showName: showDetails.title,
+        originalShowName: showDetails.originalTitle,
         tmdbId: params.showId,
-        imdbId: showDetails.external_ids.imdb_id,
-        episodeImdbId: episodeDetails.external_ids.imdb_id,
+        imdbId: showDetails.imdbId,
+        episodeImdbId: episodeDetails.imdbId,
         episodeName: episodeDetails.name,
         season: Number(params.season),
         episode: Number(params.episode),
-        releaseDate: episodeDetails.air_date,
-        backdropPath: episodeDetails.still_path ?? showDetails.backdrop_path,
+        releaseDate: episodeDetails.airDate,
+        backdropPath: episodeDetails.stillPath || showDetails.backdropPath,
     };
 
     return {